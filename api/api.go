@@ -1,9 +1,21 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/findsam/food-server/auth"
+	"github.com/findsam/food-server/config"
+	"github.com/findsam/food-server/mail"
+	"github.com/findsam/food-server/outbox"
+	"github.com/findsam/food-server/ratelimit"
 	"github.com/findsam/food-server/user"
+	u "github.com/findsam/food-server/util"
+	"github.com/findsam/food-server/webhook"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -22,20 +34,207 @@ func NewAPIServer(addr string, db *mongo.Client) *APIServer {
 }
 
 func (s *APIServer) Run() error {
+	if err := s.setupSigningKeys(); err != nil {
+		return err
+	}
+
+	revocations := auth.NewMongoRevocationList(s.db)
+	if err := revocations.EnsureIndexes(context.Background()); err != nil {
+		return err
+	}
+	auth.UseRevocationList(revocations)
+
 	r := chi.NewRouter()
 	// r.Use(middleware.Logger)
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", auth.AdminKeyHeader},
+		ExposedHeaders:   []string{"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	userStore := user.NewStore(s.db)
-	userHandler := user.NewHandler(userStore)
+	if err := userStore.EnsureIndexes(context.Background()); err != nil {
+		return err
+	}
+	passkeys, err := auth.NewPasskeys()
+	if err != nil {
+		return err
+	}
+
+	if err := auth.CurrentPasswordPolicy().Validate(); err != nil {
+		return err
+	}
+
+	if err := setupBreachIndex(); err != nil {
+		return err
+	}
+
+	mailer, err := mail.New()
+	if err != nil {
+		return err
+	}
+
+	limits, err := s.setupRateLimits()
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := s.setupWebhooks()
+	if err != nil {
+		return err
+	}
+
+	if err := s.setupOutbox(webhooks); err != nil {
+		return err
+	}
+
+	userHandler := user.NewHandler(userStore, passkeys, mailer, limits)
 	userHandler.RegisterRoutes(r)
+	webhook.NewHandler(webhooks).RegisterRoutes(r)
+	r.Get("/.well-known/jwks.json", u.MakeHTTPHandlerFunc(auth.HandleJWKS))
+
+	if config.Envs.Env == "development" {
+		r.Get("/dev/mail/{name}", u.MakeHTTPHandlerFunc(mail.HandlePreview))
+	}
 
 	return http.ListenAndServe(s.addr, r)
 }
+
+// setupWebhooks creates the webhook dispatcher over the Mongo delivery queue
+// and starts delivering in the background.
+func (s *APIServer) setupWebhooks() (*webhook.Dispatcher, error) {
+	c := config.Envs.Webhooks
+
+	store := webhook.NewMongoStore(s.db)
+	if err := store.EnsureIndexes(context.Background()); err != nil {
+		return nil, err
+	}
+
+	dispatcher := webhook.NewDispatcher(store, &http.Client{Timeout: c.Timeout}, webhook.RetryPolicy{
+		MaxAttempts: c.MaxAttempts,
+		BaseDelay:   c.BaseDelay,
+		MaxDelay:    c.MaxDelay,
+	})
+
+	go dispatcher.Run(context.Background(), c.PollInterval)
+	return dispatcher, nil
+}
+
+// setupOutbox starts the relay publishing account events from the outbox to
+// the sink chosen by OUTBOX_SINK: the webhook dispatcher, a NATS server or
+// stdout as JSON lines. The user store writes events in transactions, so it
+// refuses to start against a standalone mongod.
+func (s *APIServer) setupOutbox(webhooks *webhook.Dispatcher) error {
+	c := config.Envs.Outbox
+
+	var sink outbox.Sink
+	switch c.Sink {
+	case "webhook":
+		sink = webhooks
+	case "nats":
+		nats, err := outbox.NewNATSSink(c.NATSURL, c.NATSSubject, c.NATSTimeout)
+		if err != nil {
+			return err
+		}
+		sink = nats
+	case "stdout":
+		sink = outbox.NewStdoutSink()
+	default:
+		return fmt.Errorf("unknown outbox sink %q", c.Sink)
+	}
+
+	store := outbox.NewMongoStore(s.db)
+	if err := store.CheckTransactions(context.Background()); err != nil {
+		return err
+	}
+
+	if err := store.EnsureIndexes(context.Background()); err != nil {
+		return err
+	}
+
+	go outbox.NewRelay(store, sink, time.Second*30).Run(context.Background(), c.PollInterval)
+	return nil
+}
+
+// setupRateLimits builds the per route group rate limits. Counters are kept in
+// memory unless RATE_LIMIT_STORE=mongo shares them between replicas.
+func (s *APIServer) setupRateLimits() (user.Limits, error) {
+	c := config.Envs.RateLimit
+
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	if c.Store == "mongo" {
+		mongoStore := ratelimit.NewMongoStore(s.db)
+		if err := mongoStore.EnsureIndexes(context.Background()); err != nil {
+			return user.Limits{}, err
+		}
+		store = mongoStore
+	}
+
+	rules := map[string]ratelimit.KeyFunc{"default": ratelimit.ByIP, "auth": ratelimit.ByIP, "email": ratelimit.ByEmail, "user": ratelimit.ByUser}
+	rates := map[string]string{"default": c.Default, "auth": c.Auth, "email": c.Email, "user": c.User}
+	limits := map[string]func(http.Handler) http.Handler{}
+
+	for name, key := range rules {
+		rate, err := ratelimit.ParseRate(rates[name])
+		if err != nil {
+			return user.Limits{}, err
+		}
+		limits[name] = ratelimit.Limit(store, ratelimit.Rule{Name: name, Rate: rate, Key: key})
+	}
+
+	return user.Limits{Default: limits["default"], Auth: limits["auth"], Email: limits["email"], User: limits["user"]}, nil
+}
+
+// setupBreachIndex opens the breached password index, building it from the
+// corpus on first start. The check is disabled when neither is configured.
+func setupBreachIndex() error {
+	c := config.Envs.PasswordPolicy
+	if c.BreachIndex == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(c.BreachIndex); errors.Is(err, os.ErrNotExist) && c.BreachCorpus != "" {
+		if err := auth.BuildBreachIndex(c.BreachCorpus, c.BreachIndex); err != nil {
+			return err
+		}
+	}
+
+	idx, err := auth.OpenBreachIndex(c.BreachIndex)
+	if err != nil {
+		return err
+	}
+
+	auth.UseBreachIndex(idx)
+	return nil
+}
+
+// setupSigningKeys signs with the configured key, or with a Mongo-backed key
+// ring when JWT_KEY_ROTATION is set.
+func (s *APIServer) setupSigningKeys() error {
+	if config.Envs.JWTKeyRotation <= 0 {
+		key, err := auth.LoadSigningKey(config.Envs.JWTAlgorithm, config.Envs.JWTKeyFile)
+		if err != nil {
+			return err
+		}
+		auth.UseSigningKey(key)
+		return nil
+	}
+
+	ring := auth.NewKeyRing(auth.NewMongoKeyStore(s.db), auth.RotationPolicy{
+		Algorithm:        config.Envs.JWTAlgorithm,
+		RotateEvery:      config.Envs.JWTKeyRotation,
+		PrePublish:       config.Envs.JWTKeyPrePublish,
+		MaxTokenLifetime: auth.RefreshTokenTTL,
+	})
+	if err := ring.Rotate(context.Background(), time.Now().UTC()); err != nil {
+		return err
+	}
+	auth.UseKeyRing(ring)
+
+	go ring.Run(context.Background(), time.Minute*5)
+	return nil
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/findsam/food-server/config"
+	ge "github.com/findsam/food-server/error"
+	u "github.com/findsam/food-server/util"
+)
+
+const AdminKeyHeader = "X-Admin-Key"
+
+// WithAdminKey only lets through requests carrying ADMIN_API_KEY in the
+// X-Admin-Key header. Every request is refused while no key is configured.
+func WithAdminKey(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := config.Envs.AdminAPIKey
+		given := r.Header.Get(AdminKeyHeader)
+
+		if key == "" || subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+			u.ERROR(w, ge.Unauthorized)
+			return
+		}
+
+		handlerFunc(w, r)
+	}
+}
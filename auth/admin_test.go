@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/findsam/food-server/config"
+)
+
+func TestWithAdminKey(t *testing.T) {
+	defer func(key string) { config.Envs.AdminAPIKey = key }(config.Envs.AdminAPIKey)
+
+	handler := WithAdminKey(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		key, given string
+		want       int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		config.Envs.AdminAPIKey = c.key
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(AdminKeyHeader, c.given)
+
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != c.want {
+			t.Errorf("key %q, header %q: got status %d want %d", c.key, c.given, rec.Code, c.want)
+		}
+	}
+}
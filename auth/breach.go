@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A breach index is built once from a Have I Been Pwned corpus and queried
+// straight from disk. The layout is:
+//
+//	magic    "HIBPIDX1"
+//	buckets  65537 big endian uint64s; bucket b holds the hashes whose first
+//	         two bytes are b, at entries [buckets[b], buckets[b+1])
+//	entries  bytes 2..10 of every SHA-1 hash, sorted
+//
+// Keeping 80 bits of each hash makes a false positive vanishingly unlikely
+// while using less than half the space of the full hash.
+const (
+	breachMagic      = "HIBPIDX1"
+	breachBuckets    = 1 << 16
+	breachEntrySize  = 8
+	breachHeaderSize = len(breachMagic) + (breachBuckets+1)*8
+)
+
+const (
+	BreachBlock = "block"
+	BreachWarn  = "warn"
+)
+
+var ErrBreachCorpus = errors.New("breach corpus is malformed or not sorted by hash")
+
+type BreachIndex struct {
+	file    *os.File
+	buckets []uint64
+}
+
+var (
+	breachesMu sync.RWMutex
+	breaches   *BreachIndex
+)
+
+// UseBreachIndex enables the breached password check; nil disables it.
+func UseBreachIndex(idx *BreachIndex) {
+	breachesMu.Lock()
+	defer breachesMu.Unlock()
+	breaches = idx
+}
+
+// IsBreachedPassword reports whether password appears in the configured breach
+// index. It is always false when no index is configured.
+func IsBreachedPassword(password string) (bool, error) {
+	breachesMu.RLock()
+	idx := breaches
+	breachesMu.RUnlock()
+
+	if idx == nil {
+		return false, nil
+	}
+	return idx.Contains(password)
+}
+
+// OpenBreachIndex opens an index written by BuildBreachIndex.
+func OpenBreachIndex(path string) (*BreachIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, breachHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:len(breachMagic)]) != breachMagic {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a breach index", path)
+	}
+
+	buckets := make([]uint64, breachBuckets+1)
+	for i := range buckets {
+		buckets[i] = binary.BigEndian.Uint64(header[len(breachMagic)+i*8:])
+	}
+
+	return &BreachIndex{file: f, buckets: buckets}, nil
+}
+
+func (idx *BreachIndex) Close() error {
+	return idx.file.Close()
+}
+
+func (idx *BreachIndex) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	bucket := int(sum[0])<<8 | int(sum[1])
+	want := binary.BigEndian.Uint64(sum[2:10])
+
+	lo, hi := idx.buckets[bucket], idx.buckets[bucket+1]
+	entry := make([]byte, breachEntrySize)
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, err := idx.file.ReadAt(entry, int64(breachHeaderSize)+int64(mid)*breachEntrySize); err != nil {
+			return false, err
+		}
+
+		switch got := binary.BigEndian.Uint64(entry); {
+		case got == want:
+			return true, nil
+		case got < want:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// BuildBreachIndex writes an index for the corpus at src to dst. src is either
+// a directory of HIBP range files, each named after its five character hash
+// prefix and holding "SUFFIX:COUNT" lines, or a single file of "HASH:COUNT"
+// lines as produced by the HIBP downloader. Either way it must be sorted.
+func BuildBreachIndex(src, dst string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	b := &breachBuilder{w: bufio.NewWriter(tmp), counts: make([]uint64, breachBuckets)}
+	if _, err := b.w.Write(make([]byte, breachHeaderSize)); err != nil {
+		return err
+	}
+
+	if err := b.addCorpus(src); err != nil {
+		return err
+	}
+
+	if err := b.w.Flush(); err != nil {
+		return err
+	}
+
+	header := make([]byte, breachHeaderSize)
+	copy(header, breachMagic)
+
+	var offset uint64
+	for i := 0; i <= breachBuckets; i++ {
+		binary.BigEndian.PutUint64(header[len(breachMagic)+i*8:], offset)
+		if i < breachBuckets {
+			offset += b.counts[i]
+		}
+	}
+
+	if _, err := tmp.WriteAt(header, 0); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+type breachBuilder struct {
+	w      *bufio.Writer
+	counts []uint64
+	prev   []byte
+}
+
+func (b *breachBuilder) addCorpus(src string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return b.addFile(src, "")
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prefix := strings.ToUpper(strings.TrimSuffix(name, filepath.Ext(name)))
+		if len(prefix) != 5 {
+			continue
+		}
+		if err := b.addFile(filepath.Join(src, name), prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *breachBuilder) addFile(path, prefix string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		if err := b.add(prefix + hash); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (b *breachBuilder) add(hexHash string) error {
+	sum, err := hex.DecodeString(hexHash)
+	if err != nil || len(sum) != sha1.Size {
+		return ErrBreachCorpus
+	}
+
+	if b.prev != nil && bytes.Compare(sum, b.prev) < 0 {
+		return ErrBreachCorpus
+	}
+	b.prev = sum
+
+	b.counts[int(sum[0])<<8|int(sum[1])]++
+	_, err = b.w.Write(sum[2:10])
+	return err
+}
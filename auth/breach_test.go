@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/findsam/food-server/config"
+)
+
+var breachedPasswords = []string{"password", "123456", "qwerty", "letmein", "iloveyou", "monkey"}
+
+func sortedHashes(passwords []string) []string {
+	hashes := []string{}
+	for _, p := range passwords {
+		sum := sha1.Sum([]byte(p))
+		hashes = append(hashes, strings.ToUpper(hex.EncodeToString(sum[:])))
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+func checkBreachIndex(t *testing.T, path string) {
+	idx, err := OpenBreachIndex(path)
+	if err != nil {
+		t.Fatalf("error opening breach index: %v", err)
+	}
+	defer idx.Close()
+
+	for _, p := range breachedPasswords {
+		if found, err := idx.Contains(p); err != nil || !found {
+			t.Errorf("expected %q to be found, got %v (%v)", p, found, err)
+		}
+	}
+
+	if found, _ := idx.Contains("correct horse battery staple"); found {
+		t.Error("expected a password missing from the corpus not to be found")
+	}
+}
+
+func TestBreachIndex_SingleFile(t *testing.T) {
+	dir := t.TempDir()
+	corpus := filepath.Join(dir, "pwned.txt")
+
+	lines := []string{}
+	for i, h := range sortedHashes(breachedPasswords) {
+		lines = append(lines, fmt.Sprintf("%s:%d", h, i+1))
+	}
+	os.WriteFile(corpus, []byte(strings.Join(lines, "\r\n")), 0o600)
+
+	index := filepath.Join(dir, "pwned.idx")
+	if err := BuildBreachIndex(corpus, index); err != nil {
+		t.Fatalf("error building breach index: %v", err)
+	}
+
+	checkBreachIndex(t, index)
+}
+
+func TestBreachIndex_RangeFiles(t *testing.T) {
+	dir := t.TempDir()
+	corpus := filepath.Join(dir, "ranges")
+	os.Mkdir(corpus, 0o700)
+
+	ranges := map[string][]string{}
+	for _, h := range sortedHashes(breachedPasswords) {
+		ranges[h[:5]] = append(ranges[h[:5]], h[5:]+":10")
+	}
+	for prefix, lines := range ranges {
+		os.WriteFile(filepath.Join(corpus, prefix+".txt"), []byte(strings.Join(lines, "\n")), 0o600)
+	}
+
+	index := filepath.Join(dir, "ranges.idx")
+	if err := BuildBreachIndex(corpus, index); err != nil {
+		t.Fatalf("error building breach index: %v", err)
+	}
+
+	checkBreachIndex(t, index)
+}
+
+func TestBuildBreachIndex_Unsorted(t *testing.T) {
+	dir := t.TempDir()
+	corpus := filepath.Join(dir, "pwned.txt")
+
+	hashes := sortedHashes(breachedPasswords)
+	hashes[0], hashes[1] = hashes[1], hashes[0]
+	os.WriteFile(corpus, []byte(strings.Join(hashes, "\n")), 0o600)
+
+	if err := BuildBreachIndex(corpus, filepath.Join(dir, "pwned.idx")); err != ErrBreachCorpus {
+		t.Errorf("expected ErrBreachCorpus for an unsorted corpus, got %v", err)
+	}
+}
+
+func TestValidatePassword_Breached(t *testing.T) {
+	dir := t.TempDir()
+	corpus := filepath.Join(dir, "pwned.txt")
+	os.WriteFile(corpus, []byte(strings.Join(sortedHashes([]string{"correct horse battery staple"}), "\n")), 0o600)
+
+	index := filepath.Join(dir, "pwned.idx")
+	if err := BuildBreachIndex(corpus, index); err != nil {
+		t.Fatalf("error building breach index: %v", err)
+	}
+
+	idx, err := OpenBreachIndex(index)
+	if err != nil {
+		t.Fatalf("error opening breach index: %v", err)
+	}
+	defer idx.Close()
+
+	UseBreachIndex(idx)
+	defer UseBreachIndex(nil)
+
+	config.Envs.PasswordPolicy.BreachMode = BreachBlock
+	report, err := ValidatePassword("correct horse battery staple")
+	if err != nil || report.OK() {
+		t.Errorf("expected a breached password to be blocked, got %+v (%v)", report, err)
+	}
+
+	config.Envs.PasswordPolicy.BreachMode = BreachWarn
+	report, err = ValidatePassword("correct horse battery staple")
+	if err != nil || !report.OK() || len(report.Warnings) != 1 {
+		t.Errorf("expected only a warning in warn mode, got %+v (%v)", report, err)
+	}
+}
@@ -2,8 +2,12 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/findsam/food-server/config"
 	ge "github.com/findsam/food-server/error"
@@ -11,19 +15,105 @@ import (
 	"github.com/golang-jwt/jwt"
 )
 
+const (
+	AccessTokenTTL  = time.Minute * 5
+	RefreshTokenTTL = time.Hour * 24 * 7
+	// ResetTokenTTL is the lifetime of a password reset link. Reset tokens are
+	// stored records rather than JWTs; see GenerateResetToken.
+	ResetTokenTTL  = time.Minute * 5
+	MFATokenTTL    = time.Minute * 5
+	VerifyTokenTTL = time.Hour * 24
+	// EmailRevertTokenTTL is longer than the others as the old owner of an
+	// address may only notice a hijacked account days later.
+	EmailChangeTokenTTL = time.Hour * 24
+	EmailRevertTokenTTL = time.Hour * 24 * 7
+	UnlockTokenTTL      = time.Hour * 24
+)
+
+// TokenType stops tokens minted for one purpose from being accepted for another.
+type TokenType string
+
+const (
+	AccessToken  TokenType = "access"
+	RefreshToken TokenType = "refresh"
+	// MFAToken is the challenge handed out after a correct password when the
+	// user still has to present a second factor.
+	MFAToken TokenType = "mfa"
+	// VerifyToken proves ownership of the email address in its email claim.
+	VerifyToken TokenType = "verify"
+	// EmailChangeToken confirms a new address, EmailRevertToken restores the
+	// old one. Both carry the address in the email claim.
+	EmailChangeToken TokenType = "email_change"
+	EmailRevertToken TokenType = "email_revert"
+	// UnlockToken lifts a sign-in lockout on the account it was emailed to.
+	UnlockToken TokenType = "unlock"
+)
+
+var (
+	ErrTokenType     = errors.New("token type is not accepted here")
+	ErrTokenIssuer   = errors.New("token was not issued by this server")
+	ErrTokenAudience = errors.New("token was not issued for this audience")
+)
+
+// Claims are the claims carried by every token. SessionID is the refresh
+// family the token was issued under, when it belongs to a signed-in session.
+// Email is the address an email change or verification token applies to.
+// IssuedAtMs is iat in milliseconds, which tells apart tokens issued just
+// before and just after a RevokeAllTokens in the same second.
+type Claims struct {
+	Type       TokenType `json:"token_type"`
+	SessionID  string    `json:"sid,omitempty"`
+	Email      string    `json:"email,omitempty"`
+	IssuedAtMs int64     `json:"iat_ms,omitempty"`
+	jwt.StandardClaims
+}
+
 func ReadJWT(t *jwt.Token) string {
-	claims := t.Claims.(jwt.MapClaims)
-	uid := claims["sub"].(string)
-	return uid
+	return ReadClaims(t).Subject
 }
 
-func CreateJWT(uid string, exp int64) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": uid,
-		"exp": exp,
-	})
+func ReadClaims(t *jwt.Token) *Claims {
+	return t.Claims.(*Claims)
+}
+
+// NewClaims fills in the registered claims for a token of type typ. The jti
+// is random so individual tokens can be tracked and revoked.
+func NewClaims(typ TokenType, sub string, exp int64) (*Claims, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	return &Claims{
+		Type:       typ,
+		IssuedAtMs: now.UnixMilli(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        id,
+			Subject:   sub,
+			Issuer:    config.Envs.JWTIssuer,
+			Audience:  config.Envs.JWTAudience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: exp,
+		},
+	}, nil
+}
+
+func CreateJWT(typ TokenType, sub string, exp int64) (string, error) {
+	claims, err := NewClaims(typ, sub, exp)
+	if err != nil {
+		return "", err
+	}
+	return SignClaims(claims)
+}
+
+func SignClaims(claims *Claims) (string, error) {
+	key := currentSigningKey()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
 
-	str, err := token.SignedString([]byte(config.Envs.JWTSecret))
+	str, err := token.SignedString(key.signKey)
 
 	if err != nil {
 		return "", err
@@ -35,28 +125,88 @@ func CreateJWT(uid string, exp int64) (string, error) {
 func WithJWT(handlerFunc http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString := u.GetTokenFromRequest(r)
-		token, err := ValidateJWT(tokenString)
+		token, err := ValidateJWT(tokenString, AccessToken)
 
 		if err != nil {
-			u.ERROR(w, ge.Internal)
+			u.ERROR(w, TokenError(err))
 			return
 		}
 
 		if !token.Valid {
+			u.ERROR(w, ge.TokenInvalid)
+			return
+		}
+
+		claims := ReadClaims(token)
+		revoked, err := IsRevoked(r.Context(), claims)
+
+		if err != nil {
 			u.ERROR(w, ge.Internal)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), "uid", ReadJWT(token))
+		if revoked {
+			u.ERROR(w, ge.TokenRevoked)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "uid", claims.Subject)
+		ctx = context.WithValue(ctx, "claims", claims)
 		handlerFunc(w, r.WithContext(ctx))
 	}
 }
 
-func ValidateJWT(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// TokenError maps an error from ValidateJWT to the client error explaining why
+// the token was refused. Every such error is the client's fault.
+func TokenError(err error) *ge.CustomError {
+	var verr *jwt.ValidationError
+	switch {
+	case errors.Is(err, ErrTokenType):
+		return ge.WrongTokenType
+	case errors.Is(err, ErrTokenIssuer), errors.Is(err, ErrTokenAudience):
+		return ge.TokenNotAccepted
+	case errors.As(err, &verr) && verr.Errors&jwt.ValidationErrorExpired != 0:
+		return ge.TokenExpired
+	default:
+		return ge.TokenInvalid
+	}
+}
+
+// ValidateJWT verifies the signature and registered claims of tokenString and
+// only accepts tokens of type typ.
+func ValidateJWT(tokenString string, typ TokenType) (*jwt.Token, error) {
+	token, err := jwt.ParseWithClaims(tokenString, new(Claims), func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := lookupVerificationKey(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(config.Envs.JWTSecret), nil
+		return key.verifyKey, nil
 	})
+	if err != nil {
+		return token, err
+	}
+
+	claims := ReadClaims(token)
+	switch {
+	case !claims.VerifyIssuer(config.Envs.JWTIssuer, true):
+		return token, ErrTokenIssuer
+	case !claims.VerifyAudience(config.Envs.JWTAudience, true):
+		return token, ErrTokenAudience
+	case claims.Type != typ:
+		return token, ErrTokenType
+	}
+
+	return token, nil
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
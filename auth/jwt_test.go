@@ -1,12 +1,15 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/findsam/food-server/config"
+	ge "github.com/findsam/food-server/error"
 )
 
 func TestCreateJWT(t *testing.T) {
@@ -15,7 +18,7 @@ func TestCreateJWT(t *testing.T) {
 	uid := "12345"
 	exp := time.Now().Add(time.Hour).Unix()
 
-	tokenString, err := CreateJWT(uid, exp)
+	tokenString, err := CreateJWT(AccessToken, uid, exp)
 	if err != nil {
 		t.Errorf("error creating JWT: %v", err)
 	}
@@ -30,7 +33,7 @@ func TestWithJWT(t *testing.T) {
 
 	uid := "12345"
 	exp := time.Now().Add(time.Hour).Unix()
-	tokenString, _ := CreateJWT(uid, exp)
+	tokenString, _ := CreateJWT(AccessToken, uid, exp)
 
 	req := httptest.NewRequest("GET", "/", nil)
 	req.Header.Set("Authorization", "Bearer "+tokenString)
@@ -55,15 +58,15 @@ func TestValidateJWT(t *testing.T) {
 
 	uid := "12345"
 	exp := time.Now().Add(time.Hour).Unix()
-	validToken, _ := CreateJWT(uid, exp)
+	validToken, _ := CreateJWT(AccessToken, uid, exp)
 
-	token, err := ValidateJWT(validToken)
+	token, err := ValidateJWT(validToken, AccessToken)
 	if err != nil || !token.Valid {
 		t.Errorf("expected valid token, got error: %v", err)
 	}
 
 	invalidToken := validToken + "invalid"
-	_, err = ValidateJWT(invalidToken)
+	_, err = ValidateJWT(invalidToken, AccessToken)
 	if err == nil {
 		t.Error("expected an error for invalid token, but got none")
 	}
@@ -74,10 +77,10 @@ func TestValidateJWT_InvalidTokens(t *testing.T) {
 
 	uid := "12345"
 	exp := time.Now().Add(time.Hour).Unix()
-	validToken, _ := CreateJWT(uid, exp)
+	validToken, _ := CreateJWT(AccessToken, uid, exp)
 
 	manipulatedToken := validToken + "manipulated"
-	_, err := ValidateJWT(manipulatedToken)
+	_, err := ValidateJWT(manipulatedToken, AccessToken)
 	if err == nil {
 		t.Error("expected an error for manipulated token, but got none")
 	}
@@ -86,7 +89,7 @@ func TestValidateJWT_InvalidTokens(t *testing.T) {
 	invalidSecret := "differentsecret"
 	config.Envs.JWTSecret = invalidSecret
 
-	_, err = ValidateJWT(validToken)
+	_, err = ValidateJWT(validToken, AccessToken)
 	if err == nil {
 		t.Error("expected an error for token with different secret, but got none")
 	}
@@ -94,10 +97,100 @@ func TestValidateJWT_InvalidTokens(t *testing.T) {
 	config.Envs.JWTSecret = originalSecret
 
 	exp = time.Now().Add(-time.Hour).Unix()
-	expiredToken, _ := CreateJWT(uid, exp)
+	expiredToken, _ := CreateJWT(AccessToken, uid, exp)
 
-	_, err = ValidateJWT(expiredToken)
+	_, err = ValidateJWT(expiredToken, AccessToken)
 	if err == nil {
 		t.Error("expected an error for expired token, but got none")
 	}
 }
+
+func TestValidateJWT_TokenTypes(t *testing.T) {
+	config.Envs.JWTSecret = "testsecret"
+
+	exp := time.Now().Add(time.Hour).Unix()
+	refreshToken, _ := CreateJWT(RefreshToken, "12345", exp)
+	verifyToken, _ := CreateJWT(VerifyToken, "user@example.com", exp)
+
+	if _, err := ValidateJWT(refreshToken, AccessToken); !errors.Is(err, ErrTokenType) {
+		t.Errorf("expected ErrTokenType for refresh token used as access token, got %v", err)
+	}
+
+	if _, err := ValidateJWT(verifyToken, RefreshToken); !errors.Is(err, ErrTokenType) {
+		t.Errorf("expected ErrTokenType for verification token used as refresh token, got %v", err)
+	}
+
+	token, err := ValidateJWT(refreshToken, RefreshToken)
+	if err != nil {
+		t.Fatalf("expected valid refresh token, got error: %v", err)
+	}
+
+	claims := ReadClaims(token)
+	if claims.Id == "" || claims.IssuedAt == 0 || claims.NotBefore == 0 {
+		t.Errorf("expected jti, iat and nbf claims, got %+v", claims)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshToken)
+
+	rr := httptest.NewRecorder()
+	WithJWT(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called with a refresh token")
+	}).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestValidateJWT_Audience(t *testing.T) {
+	config.Envs.JWTSecret = "testsecret"
+
+	tokenString, _ := CreateJWT(AccessToken, "12345", time.Now().Add(time.Hour).Unix())
+
+	original := config.Envs.JWTAudience
+	config.Envs.JWTAudience = "another-service"
+	defer func() { config.Envs.JWTAudience = original }()
+
+	if _, err := ValidateJWT(tokenString, AccessToken); !errors.Is(err, ErrTokenAudience) {
+		t.Errorf("expected ErrTokenAudience for token issued to another audience, got %v", err)
+	}
+}
+
+func TestWithJWT_Rejections(t *testing.T) {
+	config.Envs.JWTSecret = "testsecret"
+
+	expired, _ := CreateJWT(AccessToken, "12345", time.Now().Add(-time.Hour).Unix())
+	valid, _ := CreateJWT(AccessToken, "12345", time.Now().Add(time.Hour).Unix())
+
+	cases := map[string]struct {
+		token string
+		want  *ge.CustomError
+	}{
+		"expired":   {expired, ge.TokenExpired},
+		"malformed": {"not-a-token", ge.TokenInvalid},
+		"tampered":  {valid + "x", ge.TokenInvalid},
+	}
+
+	for name, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+
+		rr := httptest.NewRecorder()
+		WithJWT(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("%s: handler should not be called", name)
+		}).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), c.want.Message) {
+			t.Errorf("%s: got %d %s, want 401 %q", name, rr.Code, rr.Body.String(), c.want.Message)
+		}
+	}
+
+	original := config.Envs.JWTIssuer
+	config.Envs.JWTIssuer = "another-issuer"
+	defer func() { config.Envs.JWTIssuer = original }()
+
+	if _, err := ValidateJWT(valid, AccessToken); TokenError(err) != ge.TokenNotAccepted {
+		t.Errorf("expected a foreign issuer to be refused as not accepted, got %v", err)
+	}
+}
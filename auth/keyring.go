@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var ErrKeyExists = errors.New("a signing key already exists for that generation")
+
+// KeyRecord is the persisted form of a SigningKey. Key holds the PKCS#8 DER
+// private key, or the raw secret for HS256.
+type KeyRecord struct {
+	Generation int       `bson:"_id"`
+	Kid        string    `bson:"kid"`
+	Algorithm  string    `bson:"algorithm"`
+	Key        []byte    `bson:"key"`
+	CreatedAt  time.Time `bson:"createdAt"`
+	ActiveAt   time.Time `bson:"activeAt"`
+	RetireAt   time.Time `bson:"retireAt,omitempty"`
+}
+
+// KeyStore persists the key ring so every replica signs with the same key.
+// InsertKey must return ErrKeyExists when the generation is already taken.
+type KeyStore interface {
+	LoadKeys(context.Context) ([]KeyRecord, error)
+	InsertKey(context.Context, KeyRecord) error
+	RetireKey(context.Context, int, time.Time) error
+	DeleteKey(context.Context, int) error
+}
+
+type RotationPolicy struct {
+	// Algorithm used for newly generated keys.
+	Algorithm string
+	// RotateEvery is how long a key stays the signing key.
+	RotateEvery time.Duration
+	// PrePublish is how long a new key is published for verification before
+	// it starts signing, so every replica and JWKS consumer has it in time.
+	PrePublish time.Duration
+	// MaxTokenLifetime is how long a key keeps verifying after it is replaced.
+	MaxTokenLifetime time.Duration
+}
+
+// KeyRing holds every key currently accepted for verification and picks the
+// signing key by generation.
+type KeyRing struct {
+	mu     sync.RWMutex
+	keys   []*SigningKey
+	store  KeyStore
+	policy RotationPolicy
+}
+
+func NewKeyRing(store KeyStore, policy RotationPolicy) *KeyRing {
+	return &KeyRing{store: store, policy: policy}
+}
+
+// NewStaticKeyRing returns a ring that only ever holds k.
+func NewStaticKeyRing(k *SigningKey) *KeyRing {
+	return &KeyRing{keys: []*SigningKey{k}}
+}
+
+// Current returns the newest key that is active at now.
+func (kr *KeyRing) Current(now time.Time) *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	var current *SigningKey
+	for _, k := range kr.keys {
+		if k.ActiveAt.After(now) || isRetired(k, now) {
+			continue
+		}
+		if current == nil || k.Generation > current.Generation {
+			current = k
+		}
+	}
+	return current
+}
+
+func (kr *KeyRing) Lookup(kid string, now time.Time) *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, k := range kr.keys {
+		if k.ID == kid && !isRetired(k, now) {
+			return k
+		}
+	}
+	return nil
+}
+
+func (kr *KeyRing) VerificationKeys(now time.Time) []*SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	keys := []*SigningKey{}
+	for _, k := range kr.keys {
+		if !isRetired(k, now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Rotate reloads the ring from the store, schedules a successor once the
+// current key is due for replacement, starts the retirement clock on replaced
+// keys and deletes keys whose retirement has passed.
+func (kr *KeyRing) Rotate(ctx context.Context, now time.Time) error {
+	if kr.store == nil {
+		return nil
+	}
+
+	keys, err := kr.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	var latest *SigningKey
+	if len(keys) > 0 {
+		latest = keys[len(keys)-1]
+	}
+
+	if latest == nil || !latest.ActiveAt.Add(kr.policy.RotateEvery-kr.policy.PrePublish).After(now) {
+		activeAt := now.Add(kr.policy.PrePublish)
+		generation := 1
+		if latest == nil {
+			activeAt = now
+		} else {
+			generation = latest.Generation + 1
+		}
+
+		err := kr.schedule(ctx, generation, activeAt, now)
+		if err != nil && !errors.Is(err, ErrKeyExists) {
+			return err
+		}
+		if keys, err = kr.load(ctx); err != nil {
+			return err
+		}
+	}
+
+	for i, k := range keys[:max(len(keys)-1, 0)] {
+		successor := keys[i+1]
+		if !k.RetireAt.IsZero() || successor.ActiveAt.After(now) {
+			continue
+		}
+		k.RetireAt = successor.ActiveAt.Add(kr.policy.MaxTokenLifetime)
+		if err := kr.store.RetireKey(ctx, k.Generation, k.RetireAt); err != nil {
+			return err
+		}
+	}
+
+	remaining := []*SigningKey{}
+	for _, k := range keys {
+		if isRetired(k, now) {
+			if err := kr.store.DeleteKey(ctx, k.Generation); err != nil {
+				return err
+			}
+			continue
+		}
+		remaining = append(remaining, k)
+	}
+
+	kr.mu.Lock()
+	kr.keys = remaining
+	kr.mu.Unlock()
+	return nil
+}
+
+// Run rotates the ring every interval until ctx is cancelled. Replicas pick up
+// keys scheduled by other replicas on their next tick.
+func (kr *KeyRing) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := kr.Rotate(ctx, time.Now().UTC()); err != nil {
+				log.Printf("signing key rotation failed: %v", err)
+			}
+		}
+	}
+}
+
+func (kr *KeyRing) schedule(ctx context.Context, generation int, activeAt, now time.Time) error {
+	k, err := GenerateSigningKey(kr.policy.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	der, ok := k.signKey.([]byte)
+	if !ok {
+		if der, err = x509.MarshalPKCS8PrivateKey(k.signKey); err != nil {
+			return err
+		}
+	}
+
+	return kr.store.InsertKey(ctx, KeyRecord{
+		Generation: generation,
+		Kid:        k.ID,
+		Algorithm:  k.Method.Alg(),
+		Key:        der,
+		CreatedAt:  now,
+		ActiveAt:   activeAt,
+	})
+}
+
+func (kr *KeyRing) load(ctx context.Context) ([]*SigningKey, error) {
+	records, err := kr.store.LoadKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0, len(records))
+	for _, rec := range records {
+		k, err := decodeKeyRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Generation < keys[j].Generation })
+	return keys, nil
+}
+
+func decodeKeyRecord(rec KeyRecord) (*SigningKey, error) {
+	k := NewHMACKey(rec.Key)
+	if rec.Algorithm != jwt.SigningMethodHS256.Alg() {
+		private, err := x509.ParsePKCS8PrivateKey(rec.Key)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, private)
+		}
+		if k, err = NewSigningKey(rec.Algorithm, signer); err != nil {
+			return nil, err
+		}
+	}
+
+	k.Generation = rec.Generation
+	k.ActiveAt = rec.ActiveAt
+	k.RetireAt = rec.RetireAt
+	return k, nil
+}
+
+func isRetired(k *SigningKey, now time.Time) bool {
+	return !k.RetireAt.IsZero() && !k.RetireAt.After(now)
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyRingRotation(t *testing.T) {
+	ctx := context.Background()
+	policy := RotationPolicy{
+		Algorithm:        "ES256",
+		RotateEvery:      time.Hour * 24,
+		PrePublish:       time.Hour,
+		MaxTokenLifetime: time.Hour * 24 * 7,
+	}
+	store := NewMemoryKeyStore()
+	ring := NewKeyRing(store, policy)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := ring.Rotate(ctx, start); err != nil {
+		t.Fatalf("error rotating key ring: %v", err)
+	}
+	first := ring.Current(start)
+	if first == nil {
+		t.Fatal("expected an initial signing key, got none")
+	}
+
+	prePublished := start.Add(policy.RotateEvery - policy.PrePublish)
+	if err := ring.Rotate(ctx, prePublished); err != nil {
+		t.Fatalf("error rotating key ring: %v", err)
+	}
+	if ring.Current(prePublished).ID != first.ID {
+		t.Error("expected the first key to keep signing until its successor is active")
+	}
+	if n := len(ring.VerificationKeys(prePublished)); n != 2 {
+		t.Errorf("expected the successor to be published for verification, got %d keys", n)
+	}
+
+	promoted := start.Add(policy.RotateEvery)
+	if err := ring.Rotate(ctx, promoted); err != nil {
+		t.Fatalf("error rotating key ring: %v", err)
+	}
+	second := ring.Current(promoted)
+	if second == nil || second.ID == first.ID {
+		t.Fatal("expected the successor to become the signing key")
+	}
+	if ring.Lookup(first.ID, promoted) == nil {
+		t.Error("expected the replaced key to keep verifying tokens")
+	}
+
+	retired := promoted.Add(policy.MaxTokenLifetime)
+	if err := ring.Rotate(ctx, retired); err != nil {
+		t.Fatalf("error rotating key ring: %v", err)
+	}
+	if ring.Lookup(first.ID, retired) != nil {
+		t.Error("expected the replaced key to be retired after the longest token lifetime")
+	}
+
+	replica := NewKeyRing(store, policy)
+	if err := replica.Rotate(ctx, retired); err != nil {
+		t.Fatalf("error rotating replica key ring: %v", err)
+	}
+	if replica.Current(retired).ID != ring.Current(retired).ID {
+		t.Error("expected replicas sharing a store to agree on the signing key")
+	}
+}
+
+func TestKeyRingVerifiesReplacedKeys(t *testing.T) {
+	defer UseSigningKey(nil)
+
+	ctx := context.Background()
+	ring := NewKeyRing(NewMemoryKeyStore(), RotationPolicy{
+		Algorithm:        "HS256",
+		RotateEvery:      time.Millisecond,
+		MaxTokenLifetime: time.Hour,
+	})
+	if err := ring.Rotate(ctx, time.Now()); err != nil {
+		t.Fatalf("error rotating key ring: %v", err)
+	}
+	UseKeyRing(ring)
+
+	tokenString, err := CreateJWT(AccessToken, "12345", time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatalf("error creating JWT: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 2)
+	if err := ring.Rotate(ctx, time.Now()); err != nil {
+		t.Fatalf("error rotating key ring: %v", err)
+	}
+
+	if _, err := ValidateJWT(tokenString, AccessToken); err != nil {
+		t.Errorf("expected token signed with a replaced key to stay valid, got error: %v", err)
+	}
+}
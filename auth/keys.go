@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/findsam/food-server/config"
+	u "github.com/findsam/food-server/util"
+	"github.com/golang-jwt/jwt"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported signing key")
+
+// SigningKey pairs a JWT signing method with the key material used to sign
+// and verify tokens. ID is emitted as the `kid` header on every token.
+// ActiveAt and RetireAt are only set for keys managed by a KeyRing.
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	Generation int
+	ActiveAt   time.Time
+	RetireAt   time.Time
+	signKey    interface{}
+	verifyKey  interface{}
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	keysMu sync.RWMutex
+	keys   *KeyRing
+)
+
+// NewHMACKey wraps a shared secret as an HS256 key. The kid is derived from a
+// digest of the secret so tokens signed with a different secret never match.
+func NewHMACKey(secret []byte) *SigningKey {
+	sum := sha256.Sum256(secret)
+	return &SigningKey{
+		ID:        "hs256-" + base64.RawURLEncoding.EncodeToString(sum[:8]),
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// NewSigningKey wraps an asymmetric private key for the given algorithm
+// (RS256, ES256 or EdDSA). The kid is the RFC 7638 thumbprint of the public key.
+func NewSigningKey(alg string, private crypto.Signer) (*SigningKey, error) {
+	k := &SigningKey{signKey: private, verifyKey: private.Public()}
+
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		if alg != jwt.SigningMethodRS256.Alg() {
+			return nil, fmt.Errorf("%w: RSA key cannot be used with %s", ErrUnsupportedKey, alg)
+		}
+		k.Method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if alg != jwt.SigningMethodES256.Alg() || key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: ES256 requires a P-256 key", ErrUnsupportedKey)
+		}
+		k.Method = jwt.SigningMethodES256
+	case ed25519.PrivateKey:
+		if alg != jwt.SigningMethodEdDSA.Alg() {
+			return nil, fmt.Errorf("%w: Ed25519 key cannot be used with %s", ErrUnsupportedKey, alg)
+		}
+		k.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, private)
+	}
+
+	jwk := k.JWK()
+	sum := sha256.Sum256(thumbprintInput(jwk))
+	k.ID = base64.RawURLEncoding.EncodeToString(sum[:])
+	return k, nil
+}
+
+// ParseSigningKeyPEM decodes a PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) private key.
+func ParseSigningKeyPEM(alg string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in signing key")
+	}
+
+	var (
+		private interface{}
+		err     error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, private)
+	}
+	return NewSigningKey(alg, signer)
+}
+
+// GenerateSigningKey creates fresh key material for alg.
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch alg {
+	case "", jwt.SigningMethodHS256.Alg():
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return NewHMACKey(secret), nil
+	case jwt.SigningMethodRS256.Alg():
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(alg, signer)
+}
+
+// LoadSigningKey builds the signing key described by the JWT_ALGORITHM and
+// JWT_PRIVATE_KEY_FILE settings. HS256 keeps using the shared JWT secret.
+func LoadSigningKey(alg, path string) (*SigningKey, error) {
+	if alg == "" || alg == jwt.SigningMethodHS256.Alg() {
+		return NewHMACKey([]byte(config.Envs.JWTSecret)), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSigningKeyPEM(alg, data)
+}
+
+// UseSigningKey makes k the only key used to sign and verify tokens. Until it
+// or UseKeyRing is called, tokens are signed with an HS256 key derived from
+// config.Envs.JWTSecret.
+func UseSigningKey(k *SigningKey) {
+	if k == nil {
+		UseKeyRing(nil)
+		return
+	}
+	UseKeyRing(NewStaticKeyRing(k))
+}
+
+func UseKeyRing(kr *KeyRing) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	keys = kr
+}
+
+func activeKeyRing() *KeyRing {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return keys
+}
+
+func currentSigningKey() *SigningKey {
+	if kr := activeKeyRing(); kr != nil {
+		if k := kr.Current(time.Now()); k != nil {
+			return k
+		}
+	}
+	return NewHMACKey([]byte(config.Envs.JWTSecret))
+}
+
+func lookupVerificationKey(kid string) *SigningKey {
+	if kr := activeKeyRing(); kr != nil {
+		if k := kr.Lookup(kid, time.Now()); k != nil {
+			return k
+		}
+	}
+	if k := currentSigningKey(); k.ID == kid {
+		return k
+	}
+	return nil
+}
+
+func (k *SigningKey) IsSymmetric() bool {
+	_, ok := k.Method.(*jwt.SigningMethodHMAC)
+	return ok
+}
+
+// JWK returns the public half of the key. Symmetric keys return an empty JWK
+// and must never be published.
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{Use: "sig", Alg: k.Method.Alg(), Kid: k.ID}
+
+	switch pub := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return JWK{}
+	}
+	return jwk
+}
+
+// JWKS returns every public verification key currently accepted, including
+// keys that are published ahead of their promotion to signing key.
+func JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	verification := []*SigningKey{currentSigningKey()}
+	if kr := activeKeyRing(); kr != nil {
+		verification = kr.VerificationKeys(time.Now())
+	}
+	for _, k := range verification {
+		if !k.IsSymmetric() {
+			set.Keys = append(set.Keys, k.JWK())
+		}
+	}
+	return set
+}
+
+func HandleJWKS(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return u.JSON(w, http.StatusOK, JWKS())
+}
+
+// thumbprintInput builds the canonical member ordering required by RFC 7638.
+func thumbprintInput(jwk JWK) []byte {
+	var v interface{}
+	switch jwk.Kty {
+	case "RSA":
+		v = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		v = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		v = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
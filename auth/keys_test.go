@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/findsam/food-server/config"
+)
+
+func generateSigner(t *testing.T, alg string) crypto.Signer {
+	t.Helper()
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch alg {
+	case "RS256":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("error generating %s key: %v", alg, err)
+	}
+	return signer
+}
+
+func TestAsymmetricSigningKeys(t *testing.T) {
+	defer UseSigningKey(nil)
+
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		der, err := x509.MarshalPKCS8PrivateKey(generateSigner(t, alg))
+		if err != nil {
+			t.Fatalf("error marshalling %s key: %v", alg, err)
+		}
+
+		key, err := ParseSigningKeyPEM(alg, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		if err != nil {
+			t.Fatalf("error parsing %s key: %v", alg, err)
+		}
+		UseSigningKey(key)
+
+		tokenString, err := CreateJWT(AccessToken, "12345", time.Now().Add(time.Hour).Unix())
+		if err != nil {
+			t.Fatalf("error creating %s JWT: %v", alg, err)
+		}
+
+		token, err := ValidateJWT(tokenString, AccessToken)
+		if err != nil || !token.Valid {
+			t.Errorf("expected valid %s token, got error: %v", alg, err)
+		}
+
+		if token.Header["kid"] != key.ID {
+			t.Errorf("expected kid %s, got %v", key.ID, token.Header["kid"])
+		}
+
+		set := JWKS()
+		if len(set.Keys) != 1 || set.Keys[0].Kid != key.ID || set.Keys[0].Alg != alg {
+			t.Errorf("expected JWKS to publish the %s key, got %+v", alg, set.Keys)
+		}
+	}
+}
+
+func TestSigningKeyAlgorithmMismatch(t *testing.T) {
+	if _, err := NewSigningKey("ES256", generateSigner(t, "EdDSA")); err == nil {
+		t.Error("expected an error using an Ed25519 key for ES256, but got none")
+	}
+}
+
+func TestHMACKeyNotPublished(t *testing.T) {
+	config.Envs.JWTSecret = "testsecret"
+	UseSigningKey(nil)
+
+	if set := JWKS(); len(set.Keys) != 0 {
+		t.Errorf("expected no published keys for HS256, got %+v", set.Keys)
+	}
+}
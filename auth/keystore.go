@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/findsam/food-server/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const KeysCollName = "signingKeys"
+
+// MongoKeyStore keeps the key ring in Mongo. The collection holds private key
+// material and must not be readable by anything but this service.
+type MongoKeyStore struct {
+	db *mongo.Client
+}
+
+func NewMongoKeyStore(db *mongo.Client) *MongoKeyStore {
+	return &MongoKeyStore{db: db}
+}
+
+func (s *MongoKeyStore) LoadKeys(ctx context.Context) ([]KeyRecord, error) {
+	col := s.db.Database(db.DbName).Collection(KeysCollName)
+
+	cursor, err := col.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	records := []KeyRecord{}
+	err = cursor.All(ctx, &records)
+	return records, err
+}
+
+func (s *MongoKeyStore) InsertKey(ctx context.Context, rec KeyRecord) error {
+	col := s.db.Database(db.DbName).Collection(KeysCollName)
+
+	_, err := col.InsertOne(ctx, rec)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrKeyExists
+	}
+	return err
+}
+
+func (s *MongoKeyStore) RetireKey(ctx context.Context, generation int, at time.Time) error {
+	col := s.db.Database(db.DbName).Collection(KeysCollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": generation}, bson.M{"$set": bson.M{"retireAt": at}})
+	return err
+}
+
+func (s *MongoKeyStore) DeleteKey(ctx context.Context, generation int) error {
+	col := s.db.Database(db.DbName).Collection(KeysCollName)
+	_, err := col.DeleteOne(ctx, bson.M{"_id": generation})
+	return err
+}
+
+// MemoryKeyStore is a KeyStore for a single process and for tests.
+type MemoryKeyStore struct {
+	mu      sync.Mutex
+	records map[int]KeyRecord
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{records: map[int]KeyRecord{}}
+}
+
+func (s *MemoryKeyStore) LoadKeys(ctx context.Context) ([]KeyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]KeyRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func (s *MemoryKeyStore) InsertKey(ctx context.Context, rec KeyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[rec.Generation]; ok {
+		return ErrKeyExists
+	}
+	s.records[rec.Generation] = rec
+	return nil
+}
+
+func (s *MemoryKeyStore) RetireKey(ctx context.Context, generation int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.records[generation]; ok && rec.RetireAt.IsZero() {
+		rec.RetireAt = at
+		s.records[generation] = rec
+	}
+	return nil
+}
+
+func (s *MemoryKeyStore) DeleteKey(ctx context.Context, generation int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, generation)
+	return nil
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/findsam/food-server/config"
+)
+
+// LockoutPolicy decides how long sign-in is refused after a number of recent
+// failures. From DelayAfter failures on each further failure doubles the wait,
+// starting at BaseDelay and capped at MaxDelay; from LockAfter failures sign-in
+// is locked for LockDuration.
+type LockoutPolicy struct {
+	DelayAfter   int
+	LockAfter    int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockDuration time.Duration
+}
+
+// AccountLockoutPolicy applies to failures for a single account.
+func AccountLockoutPolicy() LockoutPolicy {
+	c := config.Envs.Lockout
+	return LockoutPolicy{
+		DelayAfter:   c.AccountDelayAfter,
+		LockAfter:    c.AccountLockAfter,
+		BaseDelay:    c.BaseDelay,
+		MaxDelay:     c.MaxDelay,
+		LockDuration: c.LockDuration,
+	}
+}
+
+// IPLockoutPolicy applies to failures from a single IP address, whichever
+// accounts they were for.
+func IPLockoutPolicy() LockoutPolicy {
+	c := config.Envs.Lockout
+	return LockoutPolicy{
+		DelayAfter:   c.IPDelayAfter,
+		LockAfter:    c.IPLockAfter,
+		BaseDelay:    c.BaseDelay,
+		MaxDelay:     c.MaxDelay,
+		LockDuration: c.LockDuration,
+	}
+}
+
+// Locks reports whether reaching failures starts a full lockout.
+func (p LockoutPolicy) Locks(failures int) bool {
+	return p.LockAfter > 0 && failures >= p.LockAfter
+}
+
+// LockFor returns how long sign-in is refused after failures recent failures.
+func (p LockoutPolicy) LockFor(failures int) time.Duration {
+	if p.Locks(failures) {
+		return p.LockDuration
+	}
+
+	if p.DelayAfter <= 0 || failures < p.DelayAfter {
+		return 0
+	}
+
+	// stop shifting well before the duration could overflow.
+	doublings := failures - p.DelayAfter
+	if doublings > 30 {
+		return p.MaxDelay
+	}
+
+	delay := p.BaseDelay << doublings
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
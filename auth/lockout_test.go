@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicy_LockFor(t *testing.T) {
+	p := LockoutPolicy{
+		DelayAfter:   3,
+		LockAfter:    10,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockDuration: time.Hour,
+	}
+
+	cases := map[int]time.Duration{
+		0:   0,
+		2:   0,
+		3:   time.Second,
+		4:   2 * time.Second,
+		6:   8 * time.Second,
+		9:   time.Minute,
+		10:  time.Hour,
+		500: time.Hour,
+	}
+
+	for failures, want := range cases {
+		if got := p.LockFor(failures); got != want {
+			t.Errorf("LockFor(%d): got %v want %v", failures, got, want)
+		}
+	}
+}
+
+func TestLockoutPolicy_NoLock(t *testing.T) {
+	p := LockoutPolicy{DelayAfter: 1, BaseDelay: time.Second, MaxDelay: time.Hour}
+
+	if p.Locks(1000) {
+		t.Error("a policy without LockAfter should never lock")
+	}
+
+	if got := p.LockFor(1000); got != time.Hour {
+		t.Errorf("expected the delay to be capped at MaxDelay, got %v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/findsam/food-server/config"
+	t "github.com/findsam/food-server/types"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// PasskeyCeremonyTTL bounds how long a registration or assertion ceremony may
+// take between its begin and finish requests.
+const PasskeyCeremonyTTL = time.Minute * 5
+
+// NewPasskeys configures the WebAuthn relying party from config.Envs.
+// Attestation is not requested, so authenticators answer with "none".
+func NewPasskeys() (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:                  config.Envs.WebAuthnRPID,
+		RPDisplayName:         config.Envs.WebAuthnRPName,
+		RPOrigins:             strings.Split(config.Envs.WebAuthnOrigins, ","),
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: PasskeyCeremonyTTL, TimeoutUVD: PasskeyCeremonyTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: PasskeyCeremonyTTL, TimeoutUVD: PasskeyCeremonyTTL},
+		},
+	})
+}
+
+// PasskeyUser adapts a user and their stored passkeys to webauthn.User. The
+// user handle is the raw ObjectID, which carries no personal information.
+type PasskeyUser struct {
+	User     *t.User
+	Passkeys []t.Passkey
+}
+
+func (p *PasskeyUser) WebAuthnID() []byte {
+	return p.User.ID[:]
+}
+
+func (p *PasskeyUser) WebAuthnName() string {
+	return p.User.Email
+}
+
+func (p *PasskeyUser) WebAuthnDisplayName() string {
+	return strings.TrimSpace(p.User.FirstName + " " + p.User.LastName)
+}
+
+func (p *PasskeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (p *PasskeyUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, len(p.Passkeys))
+	for i, pk := range p.Passkeys {
+		creds[i] = pk.Credential
+	}
+	return creds
+}
+
+// Exclusions lists the registered credentials so an authenticator is not
+// registered twice.
+func (p *PasskeyUser) Exclusions() []protocol.CredentialDescriptor {
+	descriptors := make([]protocol.CredentialDescriptor, len(p.Passkeys))
+	for i, pk := range p.Passkeys {
+		descriptors[i] = pk.Credential.Descriptor()
+	}
+	return descriptors
+}
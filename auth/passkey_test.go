@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/findsam/food-server/types"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewPasskeys(t *testing.T) {
+	wa, err := NewPasskeys()
+	if err != nil {
+		t.Fatalf("error configuring passkeys: %v", err)
+	}
+
+	if wa.Config.AttestationPreference != "none" {
+		t.Errorf("expected attestation preference none, got %s", wa.Config.AttestationPreference)
+	}
+}
+
+func TestPasskeyUser(t *testing.T) {
+	user := &types.User{ID: primitive.NewObjectID(), FirstName: "Sam", LastName: "Smith", Email: "sam@example.com"}
+	pu := &PasskeyUser{
+		User: user,
+		Passkeys: []types.Passkey{
+			{Credential: webauthn.Credential{ID: []byte("one")}},
+			{Credential: webauthn.Credential{ID: []byte("two")}},
+		},
+	}
+
+	if !bytes.Equal(pu.WebAuthnID(), user.ID[:]) {
+		t.Error("expected the user handle to be the raw ObjectID")
+	}
+
+	if pu.WebAuthnDisplayName() != "Sam Smith" {
+		t.Errorf("unexpected display name: %s", pu.WebAuthnDisplayName())
+	}
+
+	if len(pu.WebAuthnCredentials()) != 2 || len(pu.Exclusions()) != 2 {
+		t.Error("expected every stored passkey to be offered as a credential and exclusion")
+	}
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/findsam/food-server/config"
+)
+
+// MaxPasswordLength is bcrypt's input limit; anything past it would be
+// silently ignored, so no policy may allow longer passwords.
+const MaxPasswordLength = 72
+
+// minPersonalLength stops short names such as "Al" from ruling out most
+// passwords.
+const minPersonalLength = 3
+
+const (
+	ClassUpper  = "upper"
+	ClassLower  = "lower"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// PasswordPolicy is the set of rules new passwords must satisfy.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// Require lists character classes that must all appear.
+	Require []string
+	// MinClasses is how many distinct character classes must appear.
+	MinClasses int
+	// RejectPersonal rejects passwords containing the user's name or email.
+	RejectPersonal bool
+}
+
+// PolicyViolation is a single rule a password broke, reported to clients so
+// they can explain exactly what to fix.
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// CurrentPasswordPolicy returns the policy configured by the PASSWORD_*
+// environment variables.
+func CurrentPasswordPolicy() PasswordPolicy {
+	c := config.Envs.PasswordPolicy
+	return PasswordPolicy{
+		MinLength:      c.MinLength,
+		MaxLength:      c.MaxLength,
+		Require:        c.Require,
+		MinClasses:     c.MinClasses,
+		RejectPersonal: c.RejectPersonal,
+	}
+}
+
+// Validate rejects a policy requiring character classes that do not exist,
+// which no password could satisfy.
+func (p PasswordPolicy) Validate() error {
+	for _, class := range p.Require {
+		if _, ok := classNames[class]; !ok {
+			return fmt.Errorf("unknown password character class %q, expected one of upper, lower, digit or symbol", class)
+		}
+	}
+	return nil
+}
+
+// PasswordReport is the outcome of ValidatePassword. Warnings never block a
+// password but should be shown to the user.
+type PasswordReport struct {
+	Violations []PolicyViolation `json:"violations"`
+	Warnings   []PolicyViolation `json:"warnings,omitempty"`
+}
+
+func (r PasswordReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+var breachedViolation = PolicyViolation{
+	Rule:    "breached",
+	Message: "Password has appeared in a data breach and is not safe to use",
+}
+
+var reusedViolation = PolicyViolation{
+	Rule:    "reused",
+	Message: "Password must differ from your recent passwords",
+}
+
+// CheckReuse adds a violation to r when password matches any of hashes, the
+// user's current and previous password hashes.
+func (r *PasswordReport) CheckReuse(password string, hashes ...string) {
+	for _, hash := range hashes {
+		if ComparePasswords(hash, []byte(password)) {
+			r.Violations = append(r.Violations, reusedViolation)
+			return
+		}
+	}
+}
+
+// ValidatePassword checks password against the configured policy and the
+// breached password index. personal holds the user's name and email address.
+func ValidatePassword(password string, personal ...string) (PasswordReport, error) {
+	report := PasswordReport{Violations: CurrentPasswordPolicy().Check(password, personal...)}
+
+	breached, err := IsBreachedPassword(password)
+	if err != nil {
+		return report, err
+	}
+
+	if breached {
+		if config.Envs.PasswordPolicy.BreachMode == BreachWarn {
+			report.Warnings = append(report.Warnings, breachedViolation)
+		} else {
+			report.Violations = append(report.Violations, breachedViolation)
+		}
+	}
+
+	return report, nil
+}
+
+// Check returns every rule password violates, or nil when it is acceptable.
+func (p PasswordPolicy) Check(password string, personal ...string) []PolicyViolation {
+	var violations []PolicyViolation
+
+	maxLength := p.MaxLength
+	if maxLength <= 0 || maxLength > MaxPasswordLength {
+		maxLength = MaxPasswordLength
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength || password == "" {
+		violations = append(violations, PolicyViolation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("Password must be at least %d characters long", max(p.MinLength, 1)),
+		})
+	}
+
+	if len(password) > maxLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    "max_length",
+			Message: fmt.Sprintf("Password must be at most %d bytes long", maxLength),
+		})
+	}
+
+	classes := passwordClasses(password)
+	for _, class := range p.Require {
+		if !classes[class] {
+			violations = append(violations, PolicyViolation{
+				Rule:    class,
+				Message: fmt.Sprintf("Password must contain a %s character", classNames[class]),
+			})
+		}
+	}
+
+	if len(classes) < p.MinClasses {
+		violations = append(violations, PolicyViolation{
+			Rule:    "min_classes",
+			Message: fmt.Sprintf("Password must mix at least %d of uppercase, lowercase, digit and symbol characters", p.MinClasses),
+		})
+	}
+
+	if p.RejectPersonal && containsPersonal(password, personal) {
+		violations = append(violations, PolicyViolation{
+			Rule:    "personal_info",
+			Message: "Password must not contain your name or email address",
+		})
+	}
+
+	return violations
+}
+
+var classNames = map[string]string{
+	ClassUpper:  "uppercase",
+	ClassLower:  "lowercase",
+	ClassDigit:  "digit",
+	ClassSymbol: "symbol",
+}
+
+func passwordClasses(password string) map[string]bool {
+	classes := map[string]bool{}
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			classes[ClassUpper] = true
+		case unicode.IsLower(r):
+			classes[ClassLower] = true
+		case unicode.IsDigit(r):
+			classes[ClassDigit] = true
+		default:
+			classes[ClassSymbol] = true
+		}
+	}
+	return classes
+}
+
+// containsPersonal reports whether password contains any of personal, or the
+// local part of an email address among them, ignoring case.
+func containsPersonal(password string, personal []string) bool {
+	password = strings.ToLower(password)
+
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		candidates := []string{value}
+		if at := strings.LastIndex(value, "@"); at != -1 {
+			candidates = append(candidates, value[:at])
+		}
+
+		for _, c := range candidates {
+			if len(c) >= minPersonalLength && strings.Contains(password, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func rules(violations []PolicyViolation) string {
+	names := []string{}
+	for _, v := range violations {
+		names = append(names, v.Rule)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestPasswordPolicy_Length(t *testing.T) {
+	p := PasswordPolicy{MinLength: 8, MaxLength: 128}
+
+	if got := rules(p.Check("")); got != "min_length" {
+		t.Errorf("expected the empty password to be too short, got %q", got)
+	}
+
+	if got := rules(p.Check("short")); got != "min_length" {
+		t.Errorf("expected min_length, got %q", got)
+	}
+
+	// the minimum counts characters, not bytes.
+	if got := rules(p.Check("ñññññññ")); got != "min_length" {
+		t.Errorf("expected 7 two-byte characters to be too short, got %q", got)
+	}
+
+	if got := rules(p.Check(strings.Repeat("a", MaxPasswordLength+1))); got != "max_length" {
+		t.Errorf("expected bcrypt's limit to cap MaxLength, got %q", got)
+	}
+
+	if got := p.Check("correct horse battery staple"); got != nil {
+		t.Errorf("expected a long password to pass, got %+v", got)
+	}
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	if err := (PasswordPolicy{Require: []string{ClassUpper, ClassSymbol}}).Validate(); err != nil {
+		t.Errorf("expected known classes to be accepted, got %v", err)
+	}
+
+	if err := (PasswordPolicy{Require: []string{"digits"}}).Validate(); err == nil {
+		t.Error("expected an unknown class to be rejected")
+	}
+}
+
+func TestPasswordPolicy_Classes(t *testing.T) {
+	p := PasswordPolicy{MinLength: 1, Require: []string{ClassUpper, ClassDigit}}
+
+	if got := rules(p.Check("password")); got != "upper,digit" {
+		t.Errorf("expected upper and digit violations, got %q", got)
+	}
+
+	if got := p.Check("Passw0rd"); got != nil {
+		t.Errorf("expected Passw0rd to pass, got %+v", got)
+	}
+
+	p = PasswordPolicy{MinLength: 1, MinClasses: 3}
+	if got := rules(p.Check("Password")); got != "min_classes" {
+		t.Errorf("expected min_classes, got %q", got)
+	}
+
+	if got := p.Check("Password!"); got != nil {
+		t.Errorf("expected three classes to pass, got %+v", got)
+	}
+}
+
+func TestPasswordPolicy_Personal(t *testing.T) {
+	p := PasswordPolicy{MinLength: 1, RejectPersonal: true}
+
+	if got := rules(p.Check("iloveSamantha1", "Samantha", "Smith", "sam.smith@example.com")); got != "personal_info" {
+		t.Errorf("expected the first name to be rejected, got %q", got)
+	}
+
+	if got := rules(p.Check("x-sam.smith-x", "Samantha", "Jones", "sam.smith@example.com")); got != "personal_info" {
+		t.Errorf("expected the email local part to be rejected, got %q", got)
+	}
+
+	if got := p.Check("Al is not enough", "Al", "Bo", "al@example.com"); got != nil {
+		t.Errorf("expected very short names to be ignored, got %+v", got)
+	}
+}
+
+func TestPasswordReport_CheckReuse(t *testing.T) {
+	current, _ := HashPassword("current password")
+	previous, _ := HashPassword("previous password")
+
+	report := PasswordReport{}
+	report.CheckReuse("previous password", current, previous)
+	if rules(report.Violations) != "reused" {
+		t.Errorf("expected a reused violation, got %+v", report.Violations)
+	}
+
+	report = PasswordReport{}
+	report.CheckReuse("brand new password", current, previous)
+	if !report.OK() {
+		t.Errorf("expected a new password to pass, got %+v", report.Violations)
+	}
+}
@@ -1,11 +1,122 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/findsam/food-server/config"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+// Hasher hashes passwords into a self-describing encoded string, so a hash
+// can always be verified with the parameters it was created with.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded, which must have been
+	// produced by a Hasher of the same algorithm.
+	Verify(encoded string, password []byte) bool
+	// Outdated reports whether encoded was made with other parameters than
+	// this Hasher would use today.
+	Outdated(encoded string) bool
+}
+
+// CurrentHasher returns the hasher configured by PASSWORD_HASH and its cost
+// settings. New passwords are always hashed with it.
+func CurrentHasher() Hasher {
+	c := config.Envs.PasswordHash
+	if currentAlgorithm() == HashBcrypt {
+		return BcryptHasher{Cost: c.BcryptCost}
+	}
+	return Argon2idHasher{
+		Memory:      c.Argon2Memory,
+		Iterations:  c.Argon2Iterations,
+		Parallelism: c.Argon2Parallelism,
+	}
+}
+
+func currentAlgorithm() string {
+	if config.Envs.PasswordHash.Algorithm == HashBcrypt {
+		return HashBcrypt
+	}
+	return HashArgon2id
+}
+
+// algorithmOf identifies the algorithm encoded was hashed with.
+func algorithmOf(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return HashArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return HashBcrypt
+	}
+	return ""
+}
+
 func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return CurrentHasher().Hash(password)
+}
+
+// ComparePasswords verifies plain against hashed with whichever algorithm and
+// parameters hashed was created with.
+func ComparePasswords(hashed string, plain []byte) bool {
+	switch algorithmOf(hashed) {
+	case HashArgon2id:
+		return Argon2idHasher{}.Verify(hashed, plain)
+	case HashBcrypt:
+		return BcryptHasher{}.Verify(hashed, plain)
+	}
+	return false
+}
+
+var (
+	dummyMu     sync.Mutex
+	dummyHasher Hasher
+	dummyHash   string
+)
+
+// CompareDummyPassword spends as long as ComparePasswords would on a real
+// hash, for sign-in attempts on accounts that do not exist. The hash compared
+// against is made once per hasher configuration and never matches.
+func CompareDummyPassword(plain []byte) {
+	hasher := CurrentHasher()
+
+	dummyMu.Lock()
+	if dummyHasher != hasher {
+		if plain, err := newTokenID(); err == nil {
+			if hash, err := hasher.Hash(plain); err == nil {
+				dummyHasher, dummyHash = hasher, hash
+			}
+		}
+	}
+	hash := dummyHash
+	dummyMu.Unlock()
+
+	ComparePasswords(hash, plain)
+}
+
+// PasswordNeedsRehash reports whether hashed should be replaced by a hash from
+// CurrentHasher, because it uses another algorithm or weaker costs.
+func PasswordNeedsRehash(hashed string) bool {
+	return algorithmOf(hashed) != currentAlgorithm() || CurrentHasher().Outdated(hashed)
+}
+
+// BcryptHasher produces standard $2a$ bcrypt hashes.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost())
 	if err != nil {
 		return "", err
 	}
@@ -13,7 +124,120 @@ func HashPassword(password string) (string, error) {
 	return string(hash), nil
 }
 
-func ComparePasswords(hashed string, plain []byte) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashed), plain)
+func (h BcryptHasher) Verify(encoded string, password []byte) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), password)
 	return err == nil
 }
+
+func (h BcryptHasher) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.cost()
+}
+
+func (h BcryptHasher) cost() int {
+	if h.Cost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+	return h.Cost
+}
+
+// Argon2idHasher produces PHC strings such as
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// with unpadded base64 salt and hash. Zero parameters fall back to the OWASP
+// recommended minimums.
+type Argon2idHasher struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func (h Argon2idHasher) params() argon2Params {
+	p := argon2Params{memory: h.Memory, iterations: h.Iterations, parallelism: h.Parallelism}
+	if p.memory == 0 {
+		p.memory = 19 * 1024
+	}
+	if p.iterations == 0 {
+		p.iterations = 2
+	}
+	if p.parallelism == 0 {
+		p.parallelism = 1
+	}
+	return p
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	p := h.params()
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(encoded string, password []byte) bool {
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+
+	other := argon2.IDKey(password, salt, p.iterations, p.memory, p.parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (h Argon2idHasher) Outdated(encoded string) bool {
+	p, _, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	want := h.params()
+	return p.memory < want.memory || p.iterations < want.iterations || p.parallelism != want.parallelism || len(key) < argon2KeyLength
+}
+
+func decodeArgon2id(encoded string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version: %q", parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, fmt.Errorf("malformed argon2id hash")
+	}
+
+	return p, salt, key, nil
+}
@@ -1,7 +1,12 @@
 package auth
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/findsam/food-server/config"
+	"github.com/findsam/food-server/types"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -38,3 +43,75 @@ func TestComparePasswords(t *testing.T) {
 		t.Error("ComparePasswords should return false for non-matching password")
 	}
 }
+
+func TestArgon2idHasher(t *testing.T) {
+	h := Argon2idHasher{Memory: 8 * 1024, Iterations: 1, Parallelism: 1}
+	hash, err := h.Hash("password")
+	if err != nil {
+		t.Fatalf("error hashing a password: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$") {
+		t.Errorf("expected a PHC encoded argon2id hash, got %s", hash)
+	}
+
+	if !ComparePasswords(hash, []byte("password")) || ComparePasswords(hash, []byte("wrongPassword")) {
+		t.Error("ComparePasswords should only accept the hashed password")
+	}
+
+	if h.Outdated(hash) {
+		t.Error("a hash made with the current parameters should not be outdated")
+	}
+
+	if !(Argon2idHasher{Memory: 16 * 1024, Iterations: 1, Parallelism: 1}).Outdated(hash) {
+		t.Error("a hash made with less memory should be outdated")
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	defer func(c types.PasswordHashConfig) { config.Envs.PasswordHash = c }(config.Envs.PasswordHash)
+
+	config.Envs.PasswordHash = types.PasswordHashConfig{Algorithm: HashBcrypt, BcryptCost: bcrypt.MinCost}
+	bcryptHash, _ := HashPassword("password")
+
+	if PasswordNeedsRehash(bcryptHash) {
+		t.Error("a bcrypt hash at the configured cost should not need a rehash")
+	}
+
+	config.Envs.PasswordHash.BcryptCost = bcrypt.MinCost + 1
+	if !PasswordNeedsRehash(bcryptHash) {
+		t.Error("a bcrypt hash below the configured cost should need a rehash")
+	}
+
+	config.Envs.PasswordHash = types.PasswordHashConfig{Algorithm: HashArgon2id, Argon2Memory: 8 * 1024, Argon2Iterations: 1, Argon2Parallelism: 1}
+	if !PasswordNeedsRehash(bcryptHash) {
+		t.Error("a bcrypt hash should need a rehash once argon2id is configured")
+	}
+
+	if !ComparePasswords(bcryptHash, []byte("password")) {
+		t.Error("existing bcrypt hashes should still verify after switching algorithm")
+	}
+
+	argonHash, _ := HashPassword("password")
+	if PasswordNeedsRehash(argonHash) {
+		t.Error("a hash from the current hasher should not need a rehash")
+	}
+}
+
+func TestCompareDummyPassword(t *testing.T) {
+	defer func(c types.PasswordHashConfig) { config.Envs.PasswordHash = c }(config.Envs.PasswordHash)
+
+	config.Envs.PasswordHash = types.PasswordHashConfig{Algorithm: HashBcrypt, BcryptCost: bcrypt.MinCost}
+	CompareDummyPassword([]byte("password"))
+
+	if algorithmOf(dummyHash) != HashBcrypt {
+		t.Errorf("dummy hash should use the configured algorithm, got %q", dummyHash)
+	}
+
+	config.Envs.PasswordHash = types.PasswordHashConfig{Algorithm: HashArgon2id, Argon2Memory: 8 * 1024, Argon2Iterations: 1, Argon2Parallelism: 1}
+	CompareDummyPassword([]byte("password"))
+
+	if PasswordNeedsRehash(dummyHash) {
+		t.Error("dummy hash should follow a change of hasher configuration")
+	}
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatalf("error generating recovery codes: %v", err)
+	}
+
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("expected %d codes and hashes, got %d and %d", RecoveryCodeCount, len(codes), len(hashes))
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("expected unique recovery codes, got %s twice", code)
+		}
+		seen[code] = true
+
+		if hashes[i] == code {
+			t.Error("hash should not be equal to the plain recovery code")
+		}
+
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if HashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("expected %q to match the hash of %q", typed, code)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestGenerateResetToken(t *testing.T) {
+	tokenOne, hashOne, err := GenerateResetToken()
+	if err != nil {
+		t.Fatalf("error generating reset token: %v", err)
+	}
+
+	tokenTwo, _, _ := GenerateResetToken()
+	if tokenOne == tokenTwo {
+		t.Error("expected reset tokens to be random")
+	}
+
+	if hashOne == tokenOne || HashResetToken(tokenOne) != hashOne {
+		t.Error("expected the stored hash to be derived from, but differ from, the token")
+	}
+}
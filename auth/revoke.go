@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/findsam/food-server/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const RevokedCollName = "revokedTokens"
+
+// RevocationList records tokens that must be rejected before they expire.
+// Entries only need to live as long as the token they revoke, so stores are
+// free to forget them after their expiry.
+type RevocationList interface {
+	// Revoke rejects the single token identified by jti.
+	Revoke(ctx context.Context, jti string, exp time.Time) error
+	// RevokeSubject rejects every token for sub issued at or before before.
+	RevokeSubject(ctx context.Context, sub string, before time.Time, exp time.Time) error
+	// RevokeSession rejects every token carrying the session id sid.
+	RevokeSession(ctx context.Context, sid string, exp time.Time) error
+	IsRevoked(ctx context.Context, claims *Claims) (bool, error)
+}
+
+var (
+	revocationsMu sync.RWMutex
+	revocations   RevocationList = NewMemoryRevocationList()
+)
+
+func UseRevocationList(rl RevocationList) {
+	revocationsMu.Lock()
+	defer revocationsMu.Unlock()
+	revocations = rl
+}
+
+func activeRevocationList() RevocationList {
+	revocationsMu.RLock()
+	defer revocationsMu.RUnlock()
+	return revocations
+}
+
+// RevokeToken rejects the token described by claims until it expires.
+func RevokeToken(ctx context.Context, claims *Claims) error {
+	return RevokeTokenID(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0).UTC())
+}
+
+func RevokeTokenID(ctx context.Context, jti string, exp time.Time) error {
+	return activeRevocationList().Revoke(ctx, jti, exp)
+}
+
+// RevokeAllTokens rejects every access token already issued to sub. Refresh
+// tokens are tracked by the user store and must be revoked there.
+func RevokeAllTokens(ctx context.Context, sub string) error {
+	now := time.Now().UTC()
+	return activeRevocationList().RevokeSubject(ctx, sub, now, now.Add(AccessTokenTTL))
+}
+
+// RevokeSessionTokens rejects the access tokens issued to a single session.
+func RevokeSessionTokens(ctx context.Context, sid string) error {
+	return activeRevocationList().RevokeSession(ctx, sid, time.Now().UTC().Add(AccessTokenTTL))
+}
+
+func IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	return activeRevocationList().IsRevoked(ctx, claims)
+}
+
+type revocation struct {
+	ID        string    `bson:"_id"`
+	Before    time.Time `bson:"before,omitempty"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// MongoRevocationList stores revocations in a collection with a TTL index on
+// expiresAt so entries disappear once the original token would have expired.
+type MongoRevocationList struct {
+	db *mongo.Client
+}
+
+func NewMongoRevocationList(db *mongo.Client) *MongoRevocationList {
+	return &MongoRevocationList{db: db}
+}
+
+func (rl *MongoRevocationList) EnsureIndexes(ctx context.Context) error {
+	col := rl.db.Database(db.DbName).Collection(RevokedCollName)
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (rl *MongoRevocationList) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	col := rl.db.Database(db.DbName).Collection(RevokedCollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": "jti:" + jti}, bson.M{
+		"$max": bson.M{"expiresAt": exp},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (rl *MongoRevocationList) RevokeSubject(ctx context.Context, sub string, before time.Time, exp time.Time) error {
+	col := rl.db.Database(db.DbName).Collection(RevokedCollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": "sub:" + sub}, bson.M{
+		"$max": bson.M{"before": before, "expiresAt": exp},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (rl *MongoRevocationList) RevokeSession(ctx context.Context, sid string, exp time.Time) error {
+	col := rl.db.Database(db.DbName).Collection(RevokedCollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": "sid:" + sid}, bson.M{
+		"$max": bson.M{"expiresAt": exp},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (rl *MongoRevocationList) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	col := rl.db.Database(db.DbName).Collection(RevokedCollName)
+
+	cursor, err := col.Find(ctx, bson.M{
+		"_id": bson.M{"$in": revocationKeys(claims)},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	entries := []revocation{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return false, err
+	}
+
+	for _, e := range entries {
+		if isRevokedBy(e, claims) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MemoryRevocationList is a RevocationList for a single process and for tests.
+type MemoryRevocationList struct {
+	mu      sync.Mutex
+	entries map[string]revocation
+}
+
+func NewMemoryRevocationList() *MemoryRevocationList {
+	return &MemoryRevocationList{entries: map[string]revocation{}}
+}
+
+func (rl *MemoryRevocationList) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	rl.put(revocation{ID: "jti:" + jti, ExpiresAt: exp})
+	return nil
+}
+
+func (rl *MemoryRevocationList) RevokeSubject(ctx context.Context, sub string, before time.Time, exp time.Time) error {
+	rl.put(revocation{ID: "sub:" + sub, Before: before, ExpiresAt: exp})
+	return nil
+}
+
+func (rl *MemoryRevocationList) RevokeSession(ctx context.Context, sid string, exp time.Time) error {
+	rl.put(revocation{ID: "sid:" + sid, ExpiresAt: exp})
+	return nil
+}
+
+func (rl *MemoryRevocationList) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	for id, e := range rl.entries {
+		if !e.ExpiresAt.After(now) {
+			delete(rl.entries, id)
+		}
+	}
+
+	for _, id := range revocationKeys(claims) {
+		if e, ok := rl.entries[id]; ok && isRevokedBy(e, claims) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (rl *MemoryRevocationList) put(e revocation) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if prev, ok := rl.entries[e.ID]; ok {
+		if prev.Before.After(e.Before) {
+			e.Before = prev.Before
+		}
+		if prev.ExpiresAt.After(e.ExpiresAt) {
+			e.ExpiresAt = prev.ExpiresAt
+		}
+	}
+	rl.entries[e.ID] = e
+}
+
+func revocationKeys(claims *Claims) []string {
+	keys := []string{"jti:" + claims.Id, "sub:" + claims.Subject}
+	if claims.SessionID != "" {
+		keys = append(keys, "sid:"+claims.SessionID)
+	}
+	return keys
+}
+
+func isRevokedBy(e revocation, claims *Claims) bool {
+	if e.Before.IsZero() {
+		return true
+	}
+
+	// tokens without iat_ms only know their second, so one issued in the
+	// same second as the cut-off is treated as issued before it.
+	if claims.IssuedAtMs == 0 {
+		return claims.IssuedAt <= e.Before.Unix()
+	}
+	return claims.IssuedAtMs < e.Before.UnixMilli()
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/findsam/food-server/config"
+)
+
+func TestWithJWT_RevokedToken(t *testing.T) {
+	config.Envs.JWTSecret = "testsecret"
+	UseRevocationList(NewMemoryRevocationList())
+
+	claims, _ := NewClaims(AccessToken, "12345", time.Now().Add(time.Hour).Unix())
+	tokenString, _ := SignClaims(claims)
+
+	if err := RevokeToken(context.Background(), claims); err != nil {
+		t.Fatalf("error revoking token: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	rr := httptest.NewRecorder()
+	WithJWT(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called with a revoked token")
+	}).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRevokeAllTokens(t *testing.T) {
+	ctx := context.Background()
+	UseRevocationList(NewMemoryRevocationList())
+
+	before, _ := NewClaims(AccessToken, "12345", time.Now().Add(time.Hour).Unix())
+	other, _ := NewClaims(AccessToken, "67890", time.Now().Add(time.Hour).Unix())
+	time.Sleep(2 * time.Millisecond)
+
+	if err := RevokeAllTokens(ctx, "12345"); err != nil {
+		t.Fatalf("error revoking tokens: %v", err)
+	}
+
+	if revoked, _ := IsRevoked(ctx, before); !revoked {
+		t.Error("expected tokens issued before sign-out to be revoked")
+	}
+
+	if revoked, _ := IsRevoked(ctx, other); revoked {
+		t.Error("expected tokens of other users to stay valid")
+	}
+
+	after, _ := NewClaims(AccessToken, "12345", time.Now().Add(time.Hour).Unix())
+	if revoked, _ := IsRevoked(ctx, after); revoked {
+		t.Error("expected tokens issued right after sign-out to stay valid")
+	}
+}
+
+func TestRevokeSubject_SameSecond(t *testing.T) {
+	ctx := context.Background()
+	rl := NewMemoryRevocationList()
+
+	second := time.Now().Add(time.Minute).Truncate(time.Second)
+	rl.RevokeSubject(ctx, "12345", second.Add(500*time.Millisecond), second.Add(time.Hour))
+
+	issued := func(ms int64, withMs bool) *Claims {
+		claims, _ := NewClaims(AccessToken, "12345", second.Add(time.Hour).Unix())
+		claims.IssuedAt = second.Unix()
+		claims.IssuedAtMs = 0
+		if withMs {
+			claims.IssuedAtMs = second.UnixMilli() + ms
+		}
+		return claims
+	}
+
+	if revoked, _ := rl.IsRevoked(ctx, issued(700, true)); revoked {
+		t.Error("expected a sign-in later in the same second to stay valid")
+	}
+
+	if revoked, _ := rl.IsRevoked(ctx, issued(200, true)); !revoked {
+		t.Error("expected a token from earlier in the same second to be revoked")
+	}
+
+	if revoked, _ := rl.IsRevoked(ctx, issued(0, false)); !revoked {
+		t.Error("expected a token without iat_ms from the same second to be revoked")
+	}
+}
+
+func TestMemoryRevocationListExpiry(t *testing.T) {
+	ctx := context.Background()
+	rl := NewMemoryRevocationList()
+	claims, _ := NewClaims(AccessToken, "12345", time.Now().Add(-time.Minute).Unix())
+
+	rl.Revoke(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+
+	if revoked, _ := rl.IsRevoked(ctx, claims); revoked {
+		t.Error("expected revocations to be dropped once the token has expired")
+	}
+}
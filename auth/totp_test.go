@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B uses the ASCII secret "12345678901234567890" and
+	// 8 digit codes; the last six digits are the 6 digit codes.
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range vectors {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("error computing TOTP code: %v", err)
+		}
+		if got != want {
+			t.Errorf("TOTP at %d: got %s want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("error generating TOTP secret: %v", err)
+	}
+
+	now := time.Now()
+	code, _ := TOTPCode(secret, TOTPStep(now.Add(-TOTPPeriod*time.Second)))
+
+	step, ok := ValidateTOTP(secret, code, now)
+	if !ok {
+		t.Error("expected a code from the previous period to be accepted")
+	}
+	if step != TOTPStep(now)-1 {
+		t.Errorf("expected matched step %d, got %d", TOTPStep(now)-1, step)
+	}
+
+	stale, _ := TOTPCode(secret, TOTPStep(now.Add(-5*TOTPPeriod*time.Second)))
+	if _, ok := ValidateTOTP(secret, stale, now); ok && stale != code {
+		t.Error("expected a code from five periods ago to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("JBSWY3DPEHPK3PXP", "Food", "user@example.com")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Food:user@example.com?") {
+		t.Errorf("unexpected otpauth URI: %s", uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Food") {
+		t.Errorf("expected secret and issuer in otpauth URI: %s", uri)
+	}
+}
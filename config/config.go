@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	t "github.com/findsam/food-server/types"
 	_ "github.com/joho/godotenv/autoload"
@@ -13,10 +16,83 @@ func initConfig() t.Config {
 	return t.Config{
 		Env:              getEnv("ENV", "development"),
 		Port:             getEnv("PORT", "8080"),
-		MongoURI:         getEnv("MONGODB_URI", "mongodb://localhost:27017"),
+		MongoURI:         getEnv("MONGODB_URI", "mongodb://localhost:27017"), // must be a replica set
 		PublicURL:        getEnv("PUBLIC_URL", "http://localhost:3000"),
 		JWTSecret:        getEnv("JWT_SECRET", "JWT secret is required"),
+		JWTAlgorithm:     getEnv("JWT_ALGORITHM", "HS256"),
+		JWTKeyFile:       getEnv("JWT_PRIVATE_KEY_FILE", ""),
+		JWTKeyRotation:   getEnvDuration("JWT_KEY_ROTATION", 0),
+		JWTKeyPrePublish: getEnvDuration("JWT_KEY_PREPUBLISH", time.Hour),
+		JWTIssuer:        getEnv("JWT_ISSUER", "auth-server"),
+		JWTAudience:      getEnv("JWT_AUDIENCE", "food-server"),
+		TOTPIssuer:       getEnv("TOTP_ISSUER", "auth-server"),
+		WebAuthnRPID:     getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:   getEnv("WEBAUTHN_RP_NAME", "auth-server"),
+		WebAuthnOrigins:  getEnv("WEBAUTHN_ORIGINS", "http://localhost:5173"),
+		VerifyEmail:      getEnv("EMAIL_VERIFICATION", "none"),
+		VerifyResend:     getEnvDuration("EMAIL_VERIFICATION_RESEND", time.Minute),
+		HideAccounts:     getEnvBool("HIDE_ACCOUNTS", false),
+		PasswordPolicy: t.PasswordPolicyConfig{
+			MinLength:      getEnvInt("PASSWORD_MIN_LENGTH", 8),
+			MaxLength:      getEnvInt("PASSWORD_MAX_LENGTH", 72),
+			Require:        getEnvList("PASSWORD_REQUIRE", nil),
+			MinClasses:     getEnvInt("PASSWORD_MIN_CLASSES", 0),
+			RejectPersonal: getEnvBool("PASSWORD_REJECT_PERSONAL", true),
+			BreachCorpus:   getEnv("BREACHED_PASSWORDS_CORPUS", ""),
+			BreachIndex:    getEnv("BREACHED_PASSWORDS_INDEX", ""),
+			BreachMode:     getEnv("BREACHED_PASSWORDS_MODE", "block"),
+			History:        getEnvInt("PASSWORD_HISTORY", 5),
+		},
+		PasswordHash: t.PasswordHashConfig{
+			Algorithm:         getEnv("PASSWORD_HASH", "argon2id"),
+			Argon2Memory:      uint32(getEnvInt("ARGON2_MEMORY", 19*1024)),
+			Argon2Iterations:  uint32(getEnvInt("ARGON2_ITERATIONS", 2)),
+			Argon2Parallelism: uint8(getEnvInt("ARGON2_PARALLELISM", 1)),
+			BcryptCost:        getEnvInt("BCRYPT_COST", 10),
+		},
+		Lockout: t.LockoutConfig{
+			AccountDelayAfter: getEnvInt("LOCKOUT_ACCOUNT_DELAY_AFTER", 3),
+			AccountLockAfter:  getEnvInt("LOCKOUT_ACCOUNT_LOCK_AFTER", 10),
+			IPDelayAfter:      getEnvInt("LOCKOUT_IP_DELAY_AFTER", 10),
+			IPLockAfter:       getEnvInt("LOCKOUT_IP_LOCK_AFTER", 50),
+			BaseDelay:         getEnvDuration("LOCKOUT_BASE_DELAY", time.Second),
+			MaxDelay:          getEnvDuration("LOCKOUT_MAX_DELAY", 5*time.Minute),
+			LockDuration:      getEnvDuration("LOCKOUT_DURATION", 30*time.Minute),
+			Window:            getEnvDuration("LOCKOUT_WINDOW", time.Hour),
+		},
+		RateLimit: t.RateLimitConfig{
+			Store:   getEnv("RATE_LIMIT_STORE", "memory"),
+			Default: getEnv("RATE_LIMIT_DEFAULT", "300/1m"),
+			Auth:    getEnv("RATE_LIMIT_AUTH", "20/1m"),
+			Email:   getEnv("RATE_LIMIT_EMAIL", "5/15m"),
+			User:    getEnv("RATE_LIMIT_USER", "120/1m"),
+		},
+		Webhooks: t.WebhookConfig{
+			MaxAttempts:  getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+			BaseDelay:    getEnvDuration("WEBHOOK_BASE_DELAY", 30*time.Second),
+			MaxDelay:     getEnvDuration("WEBHOOK_MAX_DELAY", 6*time.Hour),
+			Timeout:      getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+			PollInterval: getEnvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		},
+		Outbox: t.OutboxConfig{
+			Sink:         getEnv("OUTBOX_SINK", "webhook"),
+			PollInterval: getEnvDuration("OUTBOX_POLL_INTERVAL", time.Second),
+			NATSURL:      getEnv("NATS_URL", "nats://localhost:4222"),
+			NATSSubject:  getEnv("NATS_SUBJECT_PREFIX", "auth"),
+			NATSTimeout:  getEnvDuration("NATS_TIMEOUT", 5*time.Second),
+		},
+		BrandName:        getEnv("BRAND_NAME", "auth-server"),
+		BrandLogoURL:     getEnv("BRAND_LOGO_URL", ""),
+		BrandColor:       getEnv("BRAND_COLOR", "#18181b"),
+		MailBackend:      getEnv("MAIL_BACKEND", "file"),
+		MailFrom:         getEnv("MAIL_FROM", "auth-server <no-reply@localhost>"),
+		MailDir:          getEnv("MAIL_DIR", "tmp/mail"),
+		SMTPHost:         getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:         getEnv("SMTP_PORT", "587"),
+		SMTPUsername:     getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:     getEnv("SMTP_PASSWORD", ""),
 		APIKey:           getEnv("API_KEY", "API Key is required"),
+		AdminAPIKey:      getEnv("ADMIN_API_KEY", ""),
 		ChatGPTSecretKey: getEnv("CHATGPT_SECRET_KEY", "ChatGPT API Key is required"),
 		ChatGPTURL:       getEnv("CHATGPT_URL", "ChatGPT Url is required"),
 	}
@@ -28,3 +104,46 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+// getEnvList reads a comma separated list, dropping empty entries.
+func getEnvList(key string, fallback []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -29,4 +29,24 @@ var (
 	Unauthorized         = New("Unauthorized request", http.StatusUnauthorized)
 	UserNotFound         = New("No user was found", http.StatusBadRequest)
 	ResetExpired         = New("Reset token has expired", http.StatusBadRequest)
+	WrongTokenType       = New("Token is not valid for this request", http.StatusUnauthorized)
+	RefreshReused        = New("Refresh token has already been used", http.StatusUnauthorized)
+	TokenRevoked         = New("Token has been revoked", http.StatusUnauthorized)
+	TokenExpired         = New("Token has expired", http.StatusUnauthorized)
+	TokenInvalid         = New("Token is malformed or its signature is invalid", http.StatusUnauthorized)
+	TokenNotAccepted     = New("Token was not issued for this service", http.StatusUnauthorized)
+	TwoFactorEnabled     = New("Two-factor authentication is already enabled", http.StatusBadRequest)
+	TwoFactorNotPending  = New("No two-factor enrollment is in progress", http.StatusBadRequest)
+	IncorrectTwoFactor   = New("Two-factor code is incorrect", http.StatusBadRequest)
+	TwoFactorDisabled    = New("Two-factor authentication is not enabled", http.StatusBadRequest)
+	PasskeyRejected      = New("Passkey could not be verified", http.StatusBadRequest)
+	PasskeyNotVerified   = New("Passkey sign-in requires user verification", http.StatusUnauthorized)
+	VerifyExpired        = New("Verification token is invalid or has expired", http.StatusBadRequest)
+	EmailVerified        = New("Email address is already verified", http.StatusBadRequest)
+	EmailNotVerified     = New("Email address has not been verified", http.StatusForbidden)
+	EmailChangeExpired   = New("Email change link is invalid or has expired", http.StatusBadRequest)
+	PasswordPolicy       = New("Password does not meet the password policy", http.StatusBadRequest)
+	AccountLocked        = New("Too many failed sign-in attempts, please try again later", http.StatusTooManyRequests)
+	UnlockExpired        = New("Unlock link is invalid or has expired", http.StatusBadRequest)
+	TooManyRequests      = New("Too many requests, please try again later", http.StatusTooManyRequests)
 )
@@ -0,0 +1,110 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/findsam/food-server/config"
+)
+
+// Message is a transactional email. HTML is optional; when present the email
+// is sent as multipart/alternative with Text as the fallback part.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the Mailer selected by MAIL_BACKEND: "smtp", "file" or "memory".
+func New() (Mailer, error) {
+	switch config.Envs.MailBackend {
+	case "smtp":
+		return NewSMTPMailer(config.Envs.SMTPHost, config.Envs.SMTPPort, config.Envs.SMTPUsername, config.Envs.SMTPPassword, config.Envs.MailFrom), nil
+	case "file":
+		return NewFileMailer(config.Envs.MailDir, config.Envs.MailFrom)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail backend: %q", config.Envs.MailBackend)
+	}
+}
+
+// Bytes renders msg as an RFC 5322 message ready to hand to an SMTP server or
+// write to an .eml file.
+func (msg Message) Bytes(from string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	headers := []string{
+		"From: " + sanitizeHeader(from),
+		"To: " + sanitizeHeader(msg.To),
+		"Subject: " + mime.QEncoding.Encode("utf-8", sanitizeHeader(msg.Subject)),
+		"Date: " + time.Now().UTC().Format(time.RFC1123Z),
+		"Message-ID: " + messageID(from),
+		"MIME-Version: 1.0",
+	}
+
+	if msg.HTML == "" {
+		headers = append(headers, "Content-Type: text/plain; charset=utf-8", "Content-Transfer-Encoding: 8bit")
+		buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+		buf.WriteString(msg.Text)
+		return buf.Bytes(), nil
+	}
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	headers = append(headers, "Content-Type: multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// sanitizeHeader drops line breaks so user supplied values such as names or
+// addresses cannot inject extra headers.
+func sanitizeHeader(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+func messageID(from string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at != -1 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
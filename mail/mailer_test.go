@@ -0,0 +1,94 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMessageBytes_PlainText(t *testing.T) {
+	msg := Message{To: "user@example.com", Subject: "Reset your password", Text: "hello"}
+
+	b, err := msg.Bytes("auth-server <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("error rendering message: %v", err)
+	}
+
+	out := string(b)
+	for _, want := range []string{
+		"From: auth-server <no-reply@example.com>\r\n",
+		"To: user@example.com\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"@example.com>\r\n",
+		"\r\n\r\nhello",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in message:\n%s", want, out)
+		}
+	}
+}
+
+func TestMessageBytes_Multipart(t *testing.T) {
+	msg := Message{To: "user@example.com", Subject: "Hi", Text: "plain", HTML: "<p>html</p>"}
+
+	b, err := msg.Bytes("no-reply@example.com")
+	if err != nil {
+		t.Fatalf("error rendering message: %v", err)
+	}
+
+	out := string(b)
+	if !strings.Contains(out, "Content-Type: multipart/alternative; boundary=") {
+		t.Errorf("expected a multipart message:\n%s", out)
+	}
+	if !strings.Contains(out, "plain") || !strings.Contains(out, "<p>html</p>") {
+		t.Errorf("expected both parts in message:\n%s", out)
+	}
+}
+
+func TestMessageBytes_HeaderInjection(t *testing.T) {
+	msg := Message{To: "user@example.com\r\nBcc: victim@example.com", Subject: "Hi\nBcc: victim@example.com", Text: "body"}
+
+	b, err := msg.Bytes("no-reply@example.com")
+	if err != nil {
+		t.Fatalf("error rendering message: %v", err)
+	}
+
+	if strings.Contains(string(b), "\r\nBcc:") || strings.Contains(string(b), "\nBcc:") {
+		t.Errorf("expected line breaks to be stripped from headers:\n%s", b)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileMailer(dir, "no-reply@example.com")
+	if err != nil {
+		t.Fatalf("error creating file mailer: %v", err)
+	}
+
+	if err := m.Send(context.Background(), Message{To: "user@example.com", Subject: "Hi", Text: "body"}); err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one .eml file, got %v (%v)", files, err)
+	}
+
+	b, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(b), "To: user@example.com") {
+		t.Errorf("unexpected .eml contents:\n%s", b)
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	m.Send(context.Background(), Message{To: "a@example.com"})
+	m.Send(context.Background(), Message{To: "b@example.com"})
+
+	sent := m.Messages()
+	if len(sent) != 2 || sent[1].To != "b@example.com" {
+		t.Errorf("unexpected messages: %+v", sent)
+	}
+}
@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	ge "github.com/findsam/food-server/error"
+	u "github.com/findsam/food-server/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// HandlePreview renders a template with sample data so it can be checked in a
+// browser. It is only mounted in development.
+//
+//	GET /dev/mail/{name}?lang=es&format=text
+func HandlePreview(w http.ResponseWriter, r *http.Request) error {
+	msg, err := Render(chi.URLParam(r, "name"), "preview@example.com", Data{
+		"Name":      "Ada",
+		"Link":      Link("/preview", url.Values{"token": {"preview"}}),
+		"Minutes":   5,
+		"Hours":     24,
+		"Days":      7,
+		"NewEmail":  "new@example.com",
+		"Time":      time.Now().UTC().Format(time.RFC1123),
+		"IP":        "203.0.113.7",
+		"UserAgent": "Mozilla/5.0",
+	}, r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"))
+
+	if err != nil {
+		return u.ERROR(w, ge.NotFound)
+	}
+
+	if r.URL.Query().Get("format") == "text" || msg.HTML == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err = w.Write([]byte("Subject: " + msg.Subject + "\n\n" + msg.Text))
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = w.Write([]byte(msg.HTML))
+	return err
+}
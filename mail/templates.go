@@ -0,0 +1,139 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/findsam/food-server/config"
+	"golang.org/x/text/language"
+)
+
+// Templates live in templates/<locale>/<name>.txt and an optional
+// <name>.html. The text file defines a "subject" template and the plain text
+// body; the HTML file defines "content", which is wrapped in layout.html.
+//
+//go:embed templates
+var templateFS embed.FS
+
+// Locales are the supported template languages, the first being the fallback.
+var Locales = []language.Tag{language.English, language.Spanish}
+
+var localeMatcher = language.NewMatcher(Locales)
+
+type template struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templates maps locale to template name.
+var templates = mustParseTemplates()
+
+// Data is passed to a template. Render adds Brand, Lang and Subject to it.
+type Data map[string]interface{}
+
+type Brand struct {
+	Name    string
+	URL     string
+	LogoURL string
+	Color   string
+}
+
+// Render builds the email called name for to, in the supported locale that
+// best matches langs. langs are tried in order and may be user preferences
+// such as "es" or whole Accept-Language headers.
+func Render(name, to string, data Data, langs ...string) (Message, error) {
+	lang := Locale(langs...)
+
+	tpl, ok := templates[lang][name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown email template: %q", name)
+	}
+
+	vars := Data{
+		"Lang": lang,
+		"Brand": Brand{
+			Name:    config.Envs.BrandName,
+			URL:     config.Envs.PublicURL,
+			LogoURL: config.Envs.BrandLogoURL,
+			Color:   config.Envs.BrandColor,
+		},
+	}
+	for k, v := range data {
+		vars[k] = v
+	}
+
+	subject := new(bytes.Buffer)
+	if err := tpl.text.ExecuteTemplate(subject, "subject", vars); err != nil {
+		return Message{}, err
+	}
+	vars["Subject"] = strings.TrimSpace(subject.String())
+
+	text := new(bytes.Buffer)
+	if err := tpl.text.Execute(text, vars); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{To: to, Subject: vars["Subject"].(string), Text: text.String()}
+	if tpl.html == nil {
+		return msg, nil
+	}
+
+	html := new(bytes.Buffer)
+	if err := tpl.html.ExecuteTemplate(html, "layout.html", vars); err != nil {
+		return Message{}, err
+	}
+	msg.HTML = html.String()
+
+	return msg, nil
+}
+
+// Locale returns the supported locale that best matches langs.
+func Locale(langs ...string) string {
+	_, i := language.MatchStrings(localeMatcher, langs...)
+	return Locales[i].String()
+}
+
+// Link builds an absolute link to path on the public site.
+func Link(path string, query url.Values) string {
+	link := strings.TrimSuffix(config.Envs.PublicURL, "/") + path
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
+
+func mustParseTemplates() map[string]map[string]template {
+	layout := htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.html"))
+	parsed := map[string]map[string]template{}
+
+	for _, locale := range Locales {
+		lang := locale.String()
+		parsed[lang] = map[string]template{}
+
+		files, err := fs.Glob(templateFS, path.Join("templates", lang, "*.txt"))
+		if err != nil {
+			panic(err)
+		}
+
+		for _, file := range files {
+			name := strings.TrimSuffix(path.Base(file), ".txt")
+			tpl := template{text: texttemplate.Must(texttemplate.ParseFS(templateFS, file))}
+
+			htmlFile := strings.TrimSuffix(file, ".txt") + ".html"
+			if _, err := fs.Stat(templateFS, htmlFile); err == nil {
+				tpl.html = htmltemplate.Must(htmltemplate.Must(layout.Clone()).ParseFS(templateFS, htmlFile))
+			}
+
+			parsed[lang][name] = tpl
+		}
+	}
+
+	return parsed
+}
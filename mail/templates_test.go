@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/findsam/food-server/config"
+)
+
+func TestLocale(t *testing.T) {
+	cases := []struct {
+		langs []string
+		want  string
+	}{
+		{nil, "en"},
+		{[]string{"es"}, "es"},
+		{[]string{"", "es-MX,es;q=0.9,en;q=0.8"}, "es"},
+		{[]string{"en", "es"}, "en"},
+		{[]string{"de-DE,de;q=0.9"}, "en"},
+	}
+
+	for _, c := range cases {
+		if got := Locale(c.langs...); got != c.want {
+			t.Errorf("Locale(%q): got %s want %s", c.langs, got, c.want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	config.Envs.BrandName = "Food"
+
+	msg, err := Render("reset", "user@example.com", Data{
+		"Name":    "<Ada>",
+		"Link":    "https://example.com/reset-password?token=abc",
+		"Minutes": 5,
+	}, "es")
+	if err != nil {
+		t.Fatalf("error rendering template: %v", err)
+	}
+
+	if msg.To != "user@example.com" || msg.Subject != "Restablece tu contraseña de Food" {
+		t.Errorf("unexpected headers: %q %q", msg.To, msg.Subject)
+	}
+	if strings.Contains(msg.Text, "subject") || !strings.HasPrefix(msg.Text, "Hola <Ada>:") {
+		t.Errorf("unexpected text body:\n%s", msg.Text)
+	}
+	if !strings.Contains(msg.HTML, `lang="es"`) || !strings.Contains(msg.HTML, "&lt;Ada&gt;") {
+		t.Errorf("expected a localized, escaped HTML body:\n%s", msg.HTML)
+	}
+	if !strings.Contains(msg.HTML, "token=abc") {
+		t.Errorf("expected the link in the HTML body:\n%s", msg.HTML)
+	}
+}
+
+func TestRender_UnknownTemplate(t *testing.T) {
+	if _, err := Render("missing", "user@example.com", Data{}); err == nil {
+		t.Error("expected an error for an unknown template")
+	}
+}
+
+func TestTemplates_EveryLocale(t *testing.T) {
+	for name := range templates[Locales[0].String()] {
+		for _, locale := range Locales {
+			if _, ok := templates[locale.String()][name]; !ok {
+				t.Errorf("template %q has no %s variant", name, locale)
+			}
+		}
+	}
+}
+
+func TestLink(t *testing.T) {
+	config.Envs.PublicURL = "https://example.com/"
+
+	got := Link("/verify-email", url.Values{"token": {"a b"}})
+	if got != "https://example.com/verify-email?token=a+b" {
+		t.Errorf("unexpected link: %s", got)
+	}
+}
@@ -0,0 +1,70 @@
+package outbox
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an outbox for a single process and for tests. It does not
+// share a transaction with anything.
+type MemoryStore struct {
+	mu     sync.Mutex
+	events map[string]Event
+	owner  string
+	until  time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{events: map[string]Event{}}
+}
+
+func (s *MemoryStore) Append(ctx context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events[e.ID] = e
+	return nil
+}
+
+func (s *MemoryStore) Pending(ctx context.Context, limit int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []Event{}
+	for _, e := range s.events {
+		if e.PublishedAt.IsZero() {
+			events = append(events, e)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) MarkPublished(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.events[id]; ok {
+		e.PublishedAt = at
+		s.events[id] = e
+	}
+	return nil
+}
+
+func (s *MemoryStore) AcquireLease(ctx context.Context, owner string, now time.Time, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.owner != owner && s.until.After(now) {
+		return false, nil
+	}
+
+	s.owner, s.until = owner, until
+	return true, nil
+}
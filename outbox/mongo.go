@@ -0,0 +1,121 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/findsam/food-server/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CollName      = "outbox"
+	LeaseCollName = "outboxLeases"
+	// Retention is how long published events are kept for inspection.
+	Retention = time.Hour * 24 * 7
+)
+
+// relayLease is the id of the lease document every relay competes for.
+const relayLease = "relay"
+
+// MongoStore keeps the outbox next to the collections whose changes it
+// records, so both can be written in one transaction.
+type MongoStore struct {
+	db *mongo.Client
+}
+
+func NewMongoStore(db *mongo.Client) *MongoStore {
+	return &MongoStore{db: db}
+}
+
+// ErrNoTransactions is returned by CheckTransactions when the server cannot run
+// the transactions events are appended in.
+var ErrNoTransactions = errors.New("the outbox needs MongoDB to run as a replica set, which a standalone mongod is not; start it with --replSet and run rs.initiate() once, a single member is enough")
+
+// CheckTransactions makes sure the server supports multi-document
+// transactions, which needs a replica set or a sharded cluster, so a
+// standalone server is refused at startup rather than on the first write.
+func (s *MongoStore) CheckTransactions(ctx context.Context) error {
+	hello := struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}{}
+
+	err := s.db.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return err
+	}
+
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return ErrNoTransactions
+	}
+	return nil
+}
+
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	col := s.db.Database(db.DbName).Collection(CollName)
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "publishedAt", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// Append writes e to the outbox. Pass the mongo.SessionContext of the
+// transaction making the change e describes.
+func (s *MongoStore) Append(ctx context.Context, e Event) error {
+	col := s.db.Database(db.DbName).Collection(CollName)
+	_, err := col.InsertOne(ctx, e)
+	return err
+}
+
+func (s *MongoStore) Pending(ctx context.Context, limit int) ([]Event, error) {
+	col := s.db.Database(db.DbName).Collection(CollName)
+
+	cursor, err := col.Find(ctx, bson.M{"publishedAt": nil}, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func (s *MongoStore) MarkPublished(ctx context.Context, id string, at time.Time) error {
+	col := s.db.Database(db.DbName).Collection(CollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"publishedAt": at,
+		"expiresAt":   at.Add(Retention),
+	}})
+	return err
+}
+
+func (s *MongoStore) AcquireLease(ctx context.Context, owner string, now time.Time, until time.Time) (bool, error) {
+	col := s.db.Database(db.DbName).Collection(LeaseCollName)
+
+	// the upsert collides with the existing document, and fails, when the
+	// lease is held by another owner and has not expired.
+	_, err := col.UpdateOne(ctx, bson.M{
+		"_id": relayLease,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expiresAt": bson.M{"$lte": now}},
+		},
+	}, bson.M{"$set": bson.M{"owner": owner, "expiresAt": until}}, options.Update().SetUpsert(true))
+
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
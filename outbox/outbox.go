@@ -0,0 +1,71 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Account lifecycle events.
+const (
+	EventUserCreated       = "user.created"
+	EventUserUpdated       = "user.updated"
+	EventUserEmailVerified = "user.email_verified"
+	EventUserEmailChanged  = "user.email_changed"
+	EventUserArchived      = "user.archived"
+)
+
+// Events lists every event written to the outbox.
+var Events = []string{
+	EventUserCreated,
+	EventUserUpdated,
+	EventUserEmailVerified,
+	EventUserEmailChanged,
+	EventUserArchived,
+}
+
+// Event is a record in the outbox. It is written in the same transaction as
+// the change it describes and published by the Relay afterwards, so it is
+// published at least once if and only if the change was committed.
+type Event struct {
+	ID          string          `json:"id" bson:"_id"`
+	Type        string          `json:"type" bson:"type"`
+	Data        json.RawMessage `json:"data" bson:"data"`
+	CreatedAt   time.Time       `json:"createdAt" bson:"createdAt"`
+	PublishedAt time.Time       `json:"-" bson:"publishedAt,omitempty"`
+}
+
+// NewEvent creates an event of type typ carrying data as JSON. IDs are
+// ObjectIDs, so sorting by ID gives the order events were written in.
+func NewEvent(typ string, data interface{}) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		ID:        primitive.NewObjectID().Hex(),
+		Type:      typ,
+		Data:      raw,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Sink is where the relay publishes events. A sink may see an event more than
+// once, when the relay stops between publishing and recording it, and should
+// use the event ID to drop duplicates.
+type Sink interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Store is the outbox as the relay sees it.
+type Store interface {
+	// Pending returns up to limit unpublished events, oldest first.
+	Pending(ctx context.Context, limit int) ([]Event, error)
+	MarkPublished(ctx context.Context, id string, at time.Time) error
+	// AcquireLease makes owner the only relay allowed to publish until
+	// until, and reports false while another owner holds it.
+	AcquireLease(ctx context.Context, owner string, now time.Time, until time.Time) (bool, error)
+}
@@ -0,0 +1,86 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// relayBatch is how many events the relay loads at a time.
+const relayBatch = 100
+
+// Relay publishes outbox events to a sink in the order they were written. An
+// event that fails to publish holds back every later one until it succeeds,
+// and only the relay holding the lease publishes, so order is kept across
+// replicas too.
+type Relay struct {
+	store Store
+	sink  Sink
+	owner string
+	lease time.Duration
+}
+
+// NewRelay creates a relay that, once it holds the lease, keeps it for lease
+// after each event it publishes.
+func NewRelay(store Store, sink Sink, lease time.Duration) *Relay {
+	return &Relay{store: store, sink: sink, owner: primitive.NewObjectID().Hex(), lease: lease}
+}
+
+// Run publishes pending events every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.ProcessPending(ctx); err != nil {
+			log.Printf("outbox relay failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessPending publishes every pending event and returns how many it
+// published. It stops at the first event the sink refuses, and as soon as the
+// lease is lost to another relay. The lease is renewed before each event, so
+// a pass longer than the lease cannot overlap with another relay's.
+func (r *Relay) ProcessPending(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		events, err := r.store.Pending(ctx, relayBatch)
+		if err != nil {
+			return n, err
+		}
+
+		for _, e := range events {
+			held, err := r.renewLease(ctx)
+			if err != nil || !held {
+				return n, err
+			}
+
+			if err := r.sink.Publish(ctx, e); err != nil {
+				return n, err
+			}
+
+			if err := r.store.MarkPublished(ctx, e.ID, time.Now().UTC()); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(events) < relayBatch {
+			return n, nil
+		}
+	}
+}
+
+func (r *Relay) renewLease(ctx context.Context) (bool, error) {
+	now := time.Now().UTC()
+	return r.store.AcquireLease(ctx, r.owner, now, now.Add(r.lease))
+}
@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// recordingSink records what it publishes and refuses events listed in fail.
+type recordingSink struct {
+	published []string
+	fail      map[string]bool
+}
+
+func (s *recordingSink) Publish(ctx context.Context, e Event) error {
+	if s.fail[e.ID] {
+		return errors.New("sink unavailable")
+	}
+	s.published = append(s.published, e.ID)
+	return nil
+}
+
+func appendEvents(t *testing.T, store *MemoryStore, n int) []string {
+	ids := []string{}
+	for i := 0; i < n; i++ {
+		e, err := NewEvent(EventUserCreated, map[string]int{"n": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Append(context.Background(), e)
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestRelay_PublishesInOrder(t *testing.T) {
+	store := NewMemoryStore()
+	ids := appendEvents(t, store, relayBatch+5)
+	sink := &recordingSink{}
+
+	n, err := NewRelay(store, sink, time.Minute).ProcessPending(context.Background())
+	if err != nil || n != len(ids) {
+		t.Fatalf("published %d events (%v), want %d", n, err, len(ids))
+	}
+
+	for i, id := range ids {
+		if sink.published[i] != id {
+			t.Fatalf("event %d published out of order", i)
+		}
+	}
+
+	if pending, _ := store.Pending(context.Background(), relayBatch); len(pending) != 0 {
+		t.Errorf("%d events left pending", len(pending))
+	}
+}
+
+func TestRelay_FailureHoldsBackLaterEvents(t *testing.T) {
+	store := NewMemoryStore()
+	ids := appendEvents(t, store, 3)
+	sink := &recordingSink{fail: map[string]bool{ids[1]: true}}
+	relay := NewRelay(store, sink, time.Minute)
+
+	if n, err := relay.ProcessPending(context.Background()); err == nil || n != 1 {
+		t.Fatalf("published %d events, want to stop after the first", n)
+	}
+
+	sink.fail = nil
+	if n, err := relay.ProcessPending(context.Background()); err != nil || n != 2 {
+		t.Fatalf("published %d events (%v) once the sink recovered, want 2", n, err)
+	}
+
+	for i, id := range ids {
+		if sink.published[i] != id {
+			t.Fatalf("event %d published out of order", i)
+		}
+	}
+}
+
+func TestRelay_Lease(t *testing.T) {
+	store := NewMemoryStore()
+	appendEvents(t, store, 1)
+	ctx := context.Background()
+
+	first := NewRelay(store, &recordingSink{}, time.Minute)
+	if _, err := first.ProcessPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	appendEvents(t, store, 1)
+	sink := &recordingSink{}
+	if n, _ := NewRelay(store, sink, time.Minute).ProcessPending(ctx); n != 0 || len(sink.published) != 0 {
+		t.Error("a second relay published while the lease was held")
+	}
+
+	if n, _ := first.ProcessPending(ctx); n != 1 {
+		t.Error("the lease holder should keep publishing")
+	}
+}
+
+// stealingSink hands the lease to another relay after its first publish, as
+// if the pass had outlived the lease.
+type stealingSink struct {
+	recordingSink
+	store *MemoryStore
+}
+
+func (s *stealingSink) Publish(ctx context.Context, e Event) error {
+	if err := s.recordingSink.Publish(ctx, e); err != nil {
+		return err
+	}
+
+	later := time.Now().UTC().Add(time.Hour)
+	_, err := s.store.AcquireLease(ctx, "other", later, later.Add(time.Minute))
+	return err
+}
+
+func TestRelay_StopsWhenLeaseLost(t *testing.T) {
+	store := NewMemoryStore()
+	appendEvents(t, store, 3)
+	sink := &stealingSink{store: store}
+
+	n, err := NewRelay(store, sink, time.Minute).ProcessPending(context.Background())
+	if err != nil || n != 1 || len(sink.published) != 1 {
+		t.Fatalf("published %d events (%v) after losing the lease, want to stop after 1", n, err)
+	}
+
+	if pending, _ := store.Pending(context.Background(), relayBatch); len(pending) != 2 {
+		t.Errorf("%d events left pending, want 2", len(pending))
+	}
+}
@@ -0,0 +1,119 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLinesSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONLinesSink(&buf)
+
+	for _, typ := range []string{EventUserCreated, EventUserArchived} {
+		e, _ := NewEvent(typ, map[string]string{"id": "42"})
+		if err := sink.Publish(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+
+	e := Event{}
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil || e.Type != EventUserArchived || string(e.Data) != `{"id":"42"}` {
+		t.Errorf("unexpected line %s", lines[1])
+	}
+}
+
+// natsServer accepts one connection and speaks just enough of the NATS
+// protocol to take a publish, answering its PING with reply.
+func natsServer(t *testing.T, reply string) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+
+		msg := ""
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			msg += line
+			if line == "PING\r\n" {
+				break
+			}
+		}
+
+		received <- msg
+		conn.Write([]byte(reply))
+	}()
+
+	return "nats://user:pass@" + l.Addr().String(), received
+}
+
+func TestNATSSink_Publish(t *testing.T) {
+	url, received := natsServer(t, "PONG\r\n")
+
+	sink, err := NewNATSSink(url, "auth", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := NewEvent(EventUserCreated, map[string]string{"id": "42"})
+	if err := sink.Publish(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-received
+	payload, _ := json.Marshal(e)
+
+	if !strings.HasPrefix(msg, "CONNECT ") || !strings.Contains(msg, `"user":"user"`) {
+		t.Errorf("client did not authenticate: %q", msg)
+	}
+
+	if !strings.Contains(msg, fmt.Sprintf("PUB auth.user.created %d\r\n%s\r\n", len(payload), payload)) {
+		t.Errorf("unexpected publish %q", msg)
+	}
+}
+
+func TestNATSSink_Refused(t *testing.T) {
+	url, _ := natsServer(t, "-ERR 'Permissions Violation'\r\n")
+
+	sink, err := NewNATSSink(url, "auth", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := NewEvent(EventUserCreated, nil)
+	if err := sink.Publish(context.Background(), e); err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("got %v, want the server's error", err)
+	}
+}
+
+func TestNewNATSSink_InvalidURL(t *testing.T) {
+	if _, err := NewNATSSink("http://localhost:4222", "auth", time.Second); err == nil {
+		t.Error("only nats:// urls should be accepted")
+	}
+}
@@ -0,0 +1,37 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// JSONLinesSink writes each event as a line of JSON, for log pipelines and
+// local development.
+type JSONLinesSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+func NewStdoutSink() *JSONLinesSink {
+	return NewJSONLinesSink(os.Stdout)
+}
+
+func (s *JSONLinesSink) Publish(ctx context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in process, for a single instance and for tests.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+	sweep    time.Time
+}
+
+type counter struct {
+	start      time.Time
+	window     time.Duration
+	prev, curr int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: map[string]*counter{}}
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, rate Rate) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.evict(now)
+
+	start := now.Truncate(rate.Window)
+	c, ok := s.counters[key]
+	if !ok {
+		c = &counter{start: start, window: rate.Window}
+		s.counters[key] = c
+	}
+
+	if !c.start.Equal(start) {
+		if start.Sub(c.start) == rate.Window {
+			c.prev = c.curr
+		} else {
+			c.prev = 0
+		}
+		c.curr = 0
+		c.start = start
+	}
+
+	c.curr++
+	return slide(rate, c.prev, c.curr, start, now), nil
+}
+
+// evict drops counters that can no longer affect a result, at most once a
+// minute so it stays cheap.
+func (s *MemoryStore) evict(now time.Time) {
+	if now.Sub(s.sweep) < time.Minute {
+		return
+	}
+	s.sweep = now
+
+	for key, c := range s.counters {
+		if now.Sub(c.start) >= 2*c.window {
+			delete(s.counters, key)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ge "github.com/findsam/food-server/error"
+	u "github.com/findsam/food-server/util"
+)
+
+// KeyFunc identifies who a request counts against. An empty key skips the
+// limit for that request.
+type KeyFunc func(r *http.Request) string
+
+// ByIP limits each client address.
+func ByIP(r *http.Request) string {
+	return "ip:" + u.GetIPFromRequest(r)
+}
+
+// ByUser limits each signed-in user, and anonymous requests by address. It
+// must run after auth.WithJWT to see the user.
+func ByUser(r *http.Request) string {
+	if uid, ok := r.Context().Value("uid").(string); ok && uid != "" {
+		return "user:" + uid
+	}
+	return ByIP(r)
+}
+
+// maxKeyBody bounds how much of a request body ByEmail reads.
+const maxKeyBody = 1 << 16
+
+// ByEmail limits each email address named in the JSON body, so a single
+// address cannot be flooded from many clients. The body is left intact for
+// the handler.
+func ByEmail(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxKeyBody))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err != nil {
+		return ""
+	}
+
+	payload := struct {
+		Email string `json:"email"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Email == "" {
+		return ""
+	}
+
+	return "email:" + strings.ToLower(strings.TrimSpace(payload.Email))
+}
+
+// Rule is a rate applied to one group of routes. Name keeps the counters of
+// different groups apart.
+type Rule struct {
+	Name string
+	Rate Rate
+	Key  KeyFunc
+}
+
+// Limit returns middleware enforcing rule with store. Responses carry the
+// RateLimit-* headers from the IETF RateLimit header fields draft, and refused
+// requests a Retry-After header.
+func Limit(store Store, rule Rule) func(http.Handler) http.Handler {
+	policy := strconv.Itoa(rule.Rate.Limit) + ";w=" + strconv.Itoa(int(rule.Rate.Window.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := rule.Key(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := store.Allow(r.Context(), rule.Name+":"+key, rule.Rate)
+			if err != nil {
+				u.ERROR(w, ge.Internal)
+				return
+			}
+
+			reset := strconv.Itoa(int(res.Reset.Seconds()) + 1)
+			w.Header().Set("RateLimit-Policy", policy)
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", reset)
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", reset)
+				u.ERROR(w, ge.TooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/findsam/food-server/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const CollName = "rateLimits"
+
+// MongoStore shares counters between every replica. Each fixed window is its
+// own document, removed by a TTL index once it stops mattering.
+type MongoStore struct {
+	db *mongo.Client
+}
+
+func NewMongoStore(db *mongo.Client) *MongoStore {
+	return &MongoStore{db: db}
+}
+
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	col := s.db.Database(db.DbName).Collection(CollName)
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+type window struct {
+	ID        string    `bson:"_id"`
+	Count     int       `bson:"count"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+func (s *MongoStore) Allow(ctx context.Context, key string, rate Rate) (Result, error) {
+	col := s.db.Database(db.DbName).Collection(CollName)
+
+	now := time.Now()
+	start := now.Truncate(rate.Window)
+
+	curr := new(window)
+	err := col.FindOneAndUpdate(ctx, bson.M{"_id": windowID(key, start)}, bson.M{
+		"$inc":         bson.M{"count": 1},
+		"$setOnInsert": bson.M{"expiresAt": start.Add(2 * rate.Window).UTC()},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(curr)
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	prev := new(window)
+	err = col.FindOne(ctx, bson.M{"_id": windowID(key, start.Add(-rate.Window))}).Decode(prev)
+
+	if err != nil && err != mongo.ErrNoDocuments {
+		return Result{}, err
+	}
+
+	return slide(rate, prev.Count, curr.Count, start, now), nil
+}
+
+func windowID(key string, start time.Time) string {
+	return key + "@" + strconv.FormatInt(start.Unix(), 10)
+}
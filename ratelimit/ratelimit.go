@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store counts requests per key using a sliding window: the count for the
+// previous fixed window is weighted by how much of it still overlaps the
+// sliding one and added to the count for the current window. Every request is
+// counted, including those that end up refused.
+type Store interface {
+	Allow(ctx context.Context, key string, rate Rate) (Result, error)
+}
+
+// Rate is Limit requests per Window.
+type Rate struct {
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRate reads a rate written as "<limit>/<window>", e.g. "5/15m".
+func ParseRate(s string) (Rate, error) {
+	limit, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q, expected <limit>/<window>", s)
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate limit in %q", s)
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate window in %q", s)
+	}
+
+	return Rate{Limit: n, Window: d}, nil
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the current fixed window ends, at which point at least
+	// part of the used quota becomes available again.
+	Reset time.Duration
+}
+
+// slide turns the counts of the previous and current fixed windows into the
+// estimated number of requests in the window ending now.
+func slide(rate Rate, prev, curr int, start, now time.Time) Result {
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(rate.Window)
+	used := int(float64(prev)*weight) + curr
+
+	remaining := rate.Limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return Result{
+		Allowed:   used <= rate.Limit,
+		Limit:     rate.Limit,
+		Remaining: remaining,
+		Reset:     rate.Window - elapsed,
+	}
+}
@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	rate, err := ParseRate("5/15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rate.Limit != 5 || rate.Window != 15*time.Minute {
+		t.Errorf("got %+v", rate)
+	}
+
+	for _, bad := range []string{"", "5", "0/1m", "-1/1m", "x/1m", "5/", "5/0s", "5/soon"} {
+		if _, err := ParseRate(bad); err == nil {
+			t.Errorf("ParseRate(%q) should fail", bad)
+		}
+	}
+}
+
+func TestSlide(t *testing.T) {
+	rate := Rate{Limit: 10, Window: time.Minute}
+	start := time.Unix(0, 0)
+
+	// halfway through the window, half of the previous window still counts.
+	res := slide(rate, 10, 4, start, start.Add(30*time.Second))
+	if !res.Allowed || res.Remaining != 1 || res.Reset != 30*time.Second {
+		t.Errorf("got %+v", res)
+	}
+
+	res = slide(rate, 10, 6, start, start.Add(30*time.Second))
+	if res.Allowed || res.Remaining != 0 {
+		t.Errorf("got %+v", res)
+	}
+}
+
+func TestMemoryStore_Allow(t *testing.T) {
+	store := NewMemoryStore()
+	rate := Rate{Limit: 3, Window: time.Hour}
+
+	for i := 1; i <= 3; i++ {
+		res, err := store.Allow(context.Background(), "a", rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	if res, _ := store.Allow(context.Background(), "a", rate); res.Allowed {
+		t.Error("fourth request should be refused")
+	}
+
+	if res, _ := store.Allow(context.Background(), "b", rate); !res.Allowed {
+		t.Error("keys should be counted separately")
+	}
+}
+
+func TestLimit(t *testing.T) {
+	rule := Rule{Name: "test", Rate: Rate{Limit: 1, Window: time.Hour}, Key: ByIP}
+	handler := Limit(NewMemoryStore(), rule)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	if got := rec.Header().Get("RateLimit-Policy"); got != "1;w=3600" {
+		t.Errorf("RateLimit-Policy: got %q", got)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining: got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("refused response should carry Retry-After")
+	}
+}
+
+func TestLimit_ByUser(t *testing.T) {
+	rule := Rule{Name: "test", Rate: Rate{Limit: 1, Window: time.Hour}, Key: ByUser}
+	handler := Limit(NewMemoryStore(), rule)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(uid string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), "uid", uid))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if got := request("alice"); got != http.StatusOK {
+		t.Fatalf("first user: got status %d", got)
+	}
+	if got := request("bob"); got != http.StatusOK {
+		t.Fatalf("second user behind the same address shares a bucket: got status %d", got)
+	}
+	if got := request("alice"); got != http.StatusTooManyRequests {
+		t.Fatalf("first user again: got status %d", got)
+	}
+}
+
+func TestByEmail(t *testing.T) {
+	body := `{"email":" Sam@Example.com "}`
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+	if got := ByEmail(r); got != "email:sam@example.com" {
+		t.Errorf("got %q", got)
+	}
+
+	rest, _ := io.ReadAll(r.Body)
+	if string(rest) != body {
+		t.Errorf("body was not restored: %q", rest)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	if got := ByEmail(r); got != "" {
+		t.Errorf("got %q for a body without email", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -12,17 +13,123 @@ type Config struct {
 	Port             string
 	MongoURI         string
 	JWTSecret        string
+	JWTAlgorithm     string
+	JWTKeyFile       string
+	JWTKeyRotation   time.Duration
+	JWTKeyPrePublish time.Duration
+	JWTIssuer        string
+	JWTAudience      string
+	TOTPIssuer       string
+	WebAuthnRPID     string
+	WebAuthnRPName   string
+	WebAuthnOrigins  string
+	// VerifyEmail is one of user.VerifyNone, VerifySignIn or VerifyRoutes.
+	VerifyEmail      string
+	VerifyResend     time.Duration
+	HideAccounts     bool
+	PasswordPolicy   PasswordPolicyConfig
+	PasswordHash     PasswordHashConfig
+	Lockout          LockoutConfig
+	RateLimit        RateLimitConfig
+	Webhooks         WebhookConfig
+	Outbox           OutboxConfig
+	BrandName        string
+	BrandLogoURL     string
+	BrandColor       string
+	MailBackend      string
+	MailFrom         string
+	MailDir          string
+	SMTPHost         string
+	SMTPPort         string
+	SMTPUsername     string
+	SMTPPassword     string
 	PublicURL        string
 	APIKey           string
+	AdminAPIKey      string
 	ChatGPTSecretKey string
 	ChatGPTURL       string
 }
 
+// PasswordPolicyConfig configures auth.PasswordPolicy.
+type PasswordPolicyConfig struct {
+	MinLength int
+	MaxLength int
+	// Require lists the character classes every password must contain, any
+	// of "upper", "lower", "digit" and "symbol".
+	Require        []string
+	MinClasses     int
+	RejectPersonal bool
+	// BreachIndex is the breached password index, built from BreachCorpus
+	// when it does not exist yet. BreachMode is "block" or "warn".
+	BreachCorpus string
+	BreachIndex  string
+	BreachMode   string
+	// History is how many previous passwords cannot be reused.
+	History int
+}
+
+// PasswordHashConfig selects the password hashing algorithm, "argon2id" or
+// "bcrypt", and its costs.
+type PasswordHashConfig struct {
+	Algorithm string
+	// Argon2Memory is in KiB.
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	BcryptCost        int
+}
+
+// WebhookConfig configures outbound webhook delivery; see
+// webhook.RetryPolicy.
+type WebhookConfig struct {
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Timeout      time.Duration
+	PollInterval time.Duration
+}
+
+// OutboxConfig configures the relay publishing account events from the outbox.
+// Sink is one of "webhook", "nats" or "stdout". Events are written in Mongo
+// transactions, so MONGODB_URI must point at a replica set.
+type OutboxConfig struct {
+	Sink         string
+	PollInterval time.Duration
+	NATSURL      string
+	NATSSubject  string
+	NATSTimeout  time.Duration
+}
+
+// LockoutConfig configures the sign-in lockouts applied per account and per
+// IP address; see auth.LockoutPolicy.
+type LockoutConfig struct {
+	AccountDelayAfter int
+	AccountLockAfter  int
+	IPDelayAfter      int
+	IPLockAfter       int
+	BaseDelay         time.Duration
+	MaxDelay          time.Duration
+	LockDuration      time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// RateLimitConfig holds the rates, written "<limit>/<window>", applied to
+// each group of routes. Store is "memory" or "mongo".
+type RateLimitConfig struct {
+	Store   string
+	Default string
+	Auth    string
+	Email   string
+	User    string
+}
+
 type RegisterRequest struct {
 	FirstName string `json:"firstName" bson:"firstName" validate:"required"`
 	LastName  string `json:"lastName" bson:"lastName" validate:"required"`
 	Email     string `json:"email" bson:"email" validate:"required"`
 	Password  string `json:"password" bson:"password" validate:"required"`
+	Language  string `json:"language" bson:"language"`
 }
 
 type LoginRequest struct {
@@ -35,14 +142,90 @@ type UserStore interface {
 	GetUserByID(context.Context, string) (*User, error)
 	GetUserByEmail(context.Context, string) (*User, error)
 	UpdatePassword(context.Context, primitive.ObjectID, string) error
+	RehashPassword(context.Context, primitive.ObjectID, string, string) error
 	UpdateUser(context.Context, UpdateUserRequest) error
 	ArchiveUser(context.Context, string) error
+	SetPendingTwoFactor(context.Context, primitive.ObjectID, string) error
+	EnableTwoFactor(context.Context, primitive.ObjectID, string, int64, []string) error
+	UseTwoFactorStep(context.Context, primitive.ObjectID, int64) (bool, error)
+	SetRecoveryCodes(context.Context, primitive.ObjectID, []string) error
+	UseRecoveryCode(context.Context, primitive.ObjectID, string) (bool, error)
+	SetEmailVerified(context.Context, primitive.ObjectID, string) (bool, error)
+	MarkVerificationSent(context.Context, primitive.ObjectID, time.Time) (bool, error)
+	ClearVerificationSent(context.Context, primitive.ObjectID) error
+	SetPendingEmail(context.Context, primitive.ObjectID, string) error
+	ConfirmEmailChange(context.Context, primitive.ObjectID, string) (bool, error)
+	RevertEmailChange(context.Context, primitive.ObjectID, string) error
+	RefreshTokenStore
+	SessionStore
+	PasskeyStore
+	PasswordResetStore
+	LoginAttemptStore
+	AuditStore
+}
+
+type LoginAttemptStore interface {
+	GetLoginAttempts(context.Context, ...string) ([]LoginAttempt, error)
+	RecordLoginFailure(context.Context, string, time.Duration) (int, error)
+	LockLogin(context.Context, string, time.Time) error
+	ClearLoginAttempts(context.Context, string) error
+}
+
+type PasswordResetStore interface {
+	CreatePasswordReset(context.Context, PasswordReset) error
+	GetPasswordReset(context.Context, string) (*PasswordReset, error)
+	ConsumePasswordReset(context.Context, string) (*PasswordReset, error)
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(context.Context, RefreshToken) error
+	GetRefreshToken(context.Context, string) (*RefreshToken, error)
+	ConsumeRefreshToken(context.Context, string) (bool, error)
+	RevokeRefreshFamily(context.Context, string) error
+	RevokeUserRefreshTokens(context.Context, string) error
+}
+
+// AuditStore keeps the audit log. Events are only ever appended.
+type AuditStore interface {
+	RecordAuditEvent(context.Context, AuditEvent) error
+	GetAuditEvents(context.Context, string, int) ([]AuditEvent, error)
+}
+
+type SessionStore interface {
+	CreateSession(context.Context, Session) error
+	GetSessions(context.Context, string) ([]Session, error)
+	GetSession(context.Context, string, string) (*Session, error)
+	TouchSession(context.Context, string, string, string) error
+	DeleteSession(context.Context, string) error
+	DeleteUserSessions(context.Context, string) error
+}
+
+type PasskeyStore interface {
+	CreatePasskey(context.Context, Passkey) error
+	GetPasskeys(context.Context, string) ([]Passkey, error)
+	UpdatePasskeyCredential(context.Context, primitive.ObjectID, webauthn.Credential) error
+	DeletePasskey(context.Context, string, string) (bool, error)
+	CreatePasskeyCeremony(context.Context, PasskeyCeremony) error
+	TakePasskeyCeremony(context.Context, string) (*PasskeyCeremony, error)
 }
 
 type UserSecurity struct {
-	EmailVerified bool  `json:"emailVerified" bson:"emailVerified"`
-	HasTwoFactor  bool  `json:"hasTwoFactor" bson:"hasTwoFactor"`
-	TwoFactorCode int32 `json:"twoFactorCode" bson:"twoFactorCode"`
+	EmailVerified bool `json:"emailVerified" bson:"emailVerified"`
+	HasTwoFactor  bool `json:"hasTwoFactor" bson:"hasTwoFactor"`
+	// TwoFactorSecret is the confirmed TOTP secret, TwoFactorPending the one
+	// awaiting its first code and TwoFactorStep the last time step accepted.
+	TwoFactorSecret  string `json:"-" bson:"twoFactorSecret,omitempty"`
+	TwoFactorPending string `json:"-" bson:"twoFactorPending,omitempty"`
+	TwoFactorStep    int64  `json:"-" bson:"twoFactorStep,omitempty"`
+	// RecoveryCodes holds hashes of the unused 2FA recovery codes.
+	RecoveryCodes          []string `json:"-" bson:"recoveryCodes,omitempty"`
+	RecoveryCodesRemaining int      `json:"recoveryCodesRemaining" bson:"-"`
+	// VerificationSentAt is when the last verification email went out and is
+	// used to throttle resends.
+	VerificationSentAt time.Time `json:"-" bson:"verificationSentAt,omitempty"`
+	// PendingEmail is the address the user asked to change to. It replaces
+	// Email only once a link sent to it has been opened.
+	PendingEmail string `json:"pendingEmail,omitempty" bson:"pendingEmail,omitempty"`
 }
 
 type UserMeta struct {
@@ -57,8 +240,12 @@ type User struct {
 	LastName  string             `json:"lastName" bson:"lastName"`
 	Email     string             `json:"email" bson:"email"`
 	Password  string             `json:"-" bson:"password"`
-	Security  UserSecurity       `json:"security" bson:"security"`
-	Meta      UserMeta           `json:"meta" bson:"meta"`
+	// PasswordHistory holds the hashes of earlier passwords, newest last.
+	PasswordHistory []string `json:"-" bson:"passwordHistory,omitempty"`
+	// Language is the preferred language for emails, e.g. "en" or "es".
+	Language string       `json:"language" bson:"language,omitempty"`
+	Security UserSecurity `json:"security" bson:"security"`
+	Meta     UserMeta     `json:"meta" bson:"meta"`
 }
 
 type ResetPasswordRequest struct {
@@ -70,9 +257,133 @@ type ConfirmResetPasswordRequest struct {
 	Password string `json:"password"`
 }
 
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type UnlockRequest struct {
+	Token string `json:"token"`
+}
+
+type EmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+type TwoFactorRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorVerifyRequest struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
 type UpdateUserRequest struct {
 	ID        string `json:"id,omitempty" bson:"_id,omitempty"`
 	FirstName string `json:"firstName" bson:"firstName"`
 	LastName  string `json:"lastName" bson:"lastName"`
 	Email     string `json:"email" bson:"email"`
+	Language  string `json:"language" bson:"language"`
+}
+
+// RefreshToken tracks a single issued refresh token by its jti. Every token
+// rotated from the same sign-in shares a FamilyID.
+type RefreshToken struct {
+	ID        string    `json:"id" bson:"_id"`
+	FamilyID  string    `json:"familyId" bson:"familyId"`
+	UserID    string    `json:"userId" bson:"userId"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+	RotatedAt time.Time `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+	RevokedAt time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// AuditEvent records something that happened to an account. ActorID is the
+// user who did it, when known, and TargetID the account it happened to. Email
+// is the address given in attempts on accounts that may not exist.
+type AuditEvent struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type      string             `json:"type" bson:"type"`
+	Outcome   string             `json:"outcome" bson:"outcome"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	ActorID   string             `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	TargetID  string             `json:"targetId,omitempty" bson:"targetId,omitempty"`
+	Email     string             `json:"email,omitempty" bson:"email,omitempty"`
+	SessionID string             `json:"sessionId,omitempty" bson:"sessionId,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"userAgent" bson:"userAgent"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// AccountEvent is the data of an account lifecycle event published to other
+// services.
+type AccountEvent struct {
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	PreviousEmail string `json:"previousEmail,omitempty"`
+}
+
+// PasswordReset is an issued password reset token. Only the hash of the token
+// is stored, as its ID.
+type PasswordReset struct {
+	ID        string    `json:"-" bson:"_id"`
+	UserID    string    `json:"userId" bson:"userId"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+	UsedAt    time.Time `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+}
+
+// LoginAttempt counts recent failed sign-ins for one key, either an account
+// ("email:<address>") or a client ("ip:<address>").
+type LoginAttempt struct {
+	ID          string    `json:"id" bson:"_id"`
+	Failures    int       `json:"failures" bson:"failures"`
+	LastFailure time.Time `json:"lastFailure" bson:"lastFailure"`
+	LockedUntil time.Time `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// Session is a signed-in device. It is bound to the refresh token family
+// issued at sign-in and lives as long as that family keeps being refreshed.
+type Session struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    string             `json:"-" bson:"userId"`
+	FamilyID  string             `json:"-" bson:"familyId"`
+	UserAgent string             `json:"userAgent" bson:"userAgent"`
+	IP        string             `json:"ip" bson:"ip"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	LastSeen  time.Time          `json:"lastSeen" bson:"lastSeen"`
+	ExpiresAt time.Time          `json:"-" bson:"expiresAt"`
+	Current   bool               `json:"current" bson:"-"`
+}
+
+type Passkey struct {
+	ID         primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	UserID     string              `json:"-" bson:"userId"`
+	Name       string              `json:"name" bson:"name"`
+	Credential webauthn.Credential `json:"-" bson:"credential"`
+	CreatedAt  time.Time           `json:"createdAt" bson:"createdAt"`
+	LastUsed   time.Time           `json:"lastUsed,omitempty" bson:"lastUsed,omitempty"`
+}
+
+// PasskeyCeremony holds the WebAuthn session data between the begin and
+// finish requests of a registration or assertion. Challenge is the MFA
+// challenge a second-factor assertion was started for.
+type PasskeyCeremony struct {
+	ID        string               `bson:"_id"`
+	Purpose   string               `bson:"purpose"`
+	UserID    string               `bson:"userId,omitempty"`
+	Challenge string               `bson:"challenge,omitempty"`
+	Data      webauthn.SessionData `bson:"data"`
+	ExpiresAt time.Time            `bson:"expiresAt"`
+}
+
+type PasskeyChallengeRequest struct {
+	Challenge string `json:"challenge"`
 }
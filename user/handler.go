@@ -1,71 +1,205 @@
 package user
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/findsam/food-server/auth"
+	"github.com/findsam/food-server/config"
 	ge "github.com/findsam/food-server/error"
+	"github.com/findsam/food-server/mail"
 	t "github.com/findsam/food-server/types"
 	u "github.com/findsam/food-server/util"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Handler struct {
-	store t.UserStore
+	store    t.UserStore
+	passkeys *webauthn.WebAuthn
+	mailer   mail.Mailer
+	limits   Limits
 }
 
-func NewHandler(store t.UserStore) *Handler {
-	return &Handler{store: store}
+// Limits are the rate limiting middlewares for each group of routes. Default
+// applies to every route, Auth to routes that check credentials or tokens and
+// Email additionally to routes that send email to an address in the request.
+// User applies to routes that require an access token and runs after the
+// token is checked, so it can count requests per signed-in user.
+// A nil middleware leaves its group unlimited.
+type Limits struct {
+	Default func(http.Handler) http.Handler
+	Auth    func(http.Handler) http.Handler
+	Email   func(http.Handler) http.Handler
+	User    func(http.Handler) http.Handler
+}
+
+func NewHandler(store t.UserStore, passkeys *webauthn.WebAuthn, mailer mail.Mailer, limits Limits) *Handler {
+	return &Handler{store: store, passkeys: passkeys, mailer: mailer, limits: limits}
 }
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	r.Group(func(r chi.Router) {
 		r.Route("/users", func(r chi.Router) {
+			r.Use(unlimited(h.limits.Default))
+			strict := r.With(unlimited(h.limits.Auth))
+			mailing := strict.With(unlimited(h.limits.Email))
 			//security requests
-			r.Put("/user/confirm-reset-password", u.MakeHTTPHandlerFunc(h.handleConfirmResetPassword))
-			r.Put("/user/reset-password", u.MakeHTTPHandlerFunc(h.handlePreResetPassword))
+			strict.Put("/user/confirm-reset-password", u.MakeHTTPHandlerFunc(h.handleConfirmResetPassword))
+			mailing.Put("/user/reset-password", u.MakeHTTPHandlerFunc(h.handlePreResetPassword))
 			//generation requests
-			r.Post("/user/sign-up", u.MakeHTTPHandlerFunc(h.handleSignUp))
-			r.Post("/user/sign-in", u.MakeHTTPHandlerFunc(h.handleSignIn))
+			mailing.Post("/user/sign-up", u.MakeHTTPHandlerFunc(h.handleSignUp))
+			strict.Post("/user/sign-in", u.MakeHTTPHandlerFunc(h.handleSignIn))
+			strict.Post("/user/verify-email", u.MakeHTTPHandlerFunc(h.handleVerifyEmail))
+			mailing.Post("/user/verify-email/resend", u.MakeHTTPHandlerFunc(h.handleResendVerification))
+			strict.Post("/user/email/confirm", u.MakeHTTPHandlerFunc(h.handleConfirmEmailChange))
+			strict.Post("/user/email/revert", u.MakeHTTPHandlerFunc(h.handleRevertEmailChange))
+			strict.Post("/user/unlock", u.MakeHTTPHandlerFunc(h.handleUnlock))
+			strict.Post("/user/two-factor/verify", u.MakeHTTPHandlerFunc(h.handleVerifyTwoFactor))
+			strict.Post("/user/passkeys/login/begin", u.MakeHTTPHandlerFunc(h.handleBeginPasskeyLogin))
+			strict.Post("/user/passkeys/login/finish", u.MakeHTTPHandlerFunc(h.handleFinishPasskeyLogin))
+			strict.Post("/user/passkeys/mfa/begin", u.MakeHTTPHandlerFunc(h.handleBeginPasskeyMFA))
+			strict.Post("/user/passkeys/mfa/finish", u.MakeHTTPHandlerFunc(h.handleFinishPasskeyMFA))
 			//token required requests
-			r.Get("/user", auth.WithJWT(u.MakeHTTPHandlerFunc(h.handleSelf)))
-			r.Put("/user", auth.WithJWT(u.MakeHTTPHandlerFunc(h.handleUpdateUser)))
-			r.Delete("/user", auth.WithJWT(u.MakeHTTPHandlerFunc(h.handleArchiveUser)))
+			r.Get("/user", h.withJWT(u.MakeHTTPHandlerFunc(h.handleSelf)))
+			r.Put("/user", h.withJWT(h.withVerifiedEmail(u.MakeHTTPHandlerFunc(h.handleUpdateUser))))
+			r.Delete("/user", h.withJWT(u.MakeHTTPHandlerFunc(h.handleArchiveUser)))
+			r.Put("/user/password", h.withJWT(u.MakeHTTPHandlerFunc(h.handleChangePassword)))
+			r.Post("/user/sign-out", h.withJWT(u.MakeHTTPHandlerFunc(h.handleSignOut)))
+			r.Post("/user/sign-out-all", h.withJWT(u.MakeHTTPHandlerFunc(h.handleSignOutAll)))
+			r.Get("/user/sessions", h.withJWT(u.MakeHTTPHandlerFunc(h.handleGetSessions)))
+			r.Delete("/user/sessions/{id}", h.withJWT(u.MakeHTTPHandlerFunc(h.handleRevokeSession)))
+			r.Get("/user/activity", h.withJWT(u.MakeHTTPHandlerFunc(h.handleGetActivity)))
+			r.Post("/user/two-factor/enroll", h.withJWT(h.withVerifiedEmail(u.MakeHTTPHandlerFunc(h.handleEnrollTwoFactor))))
+			r.Post("/user/two-factor/confirm", h.withJWT(u.MakeHTTPHandlerFunc(h.handleConfirmTwoFactor)))
+			r.Post("/user/two-factor/recovery-codes", h.withJWT(u.MakeHTTPHandlerFunc(h.handleRegenerateRecoveryCodes)))
+			r.Get("/user/passkeys", h.withJWT(u.MakeHTTPHandlerFunc(h.handleGetPasskeys)))
+			r.Delete("/user/passkeys/{id}", h.withJWT(u.MakeHTTPHandlerFunc(h.handleDeletePasskey)))
+			r.Post("/user/passkeys/register/begin", h.withJWT(h.withVerifiedEmail(u.MakeHTTPHandlerFunc(h.handleBeginPasskeyRegistration))))
+			r.Post("/user/passkeys/register/finish", h.withJWT(u.MakeHTTPHandlerFunc(h.handleFinishPasskeyRegistration)))
 			//token generation requests
-			r.Get("/user/refresh", u.MakeHTTPHandlerFunc(h.handleRefresh))
+			strict.Get("/user/refresh", u.MakeHTTPHandlerFunc(h.handleRefresh))
 		})
 	})
 }
 
+// withJWT requires an access token and then applies the per user limit.
+func (h *Handler) withJWT(next http.HandlerFunc) http.HandlerFunc {
+	return auth.WithJWT(unlimited(h.limits.User)(next).ServeHTTP)
+}
+
+func unlimited(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	if mw == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return mw
+}
+
 func (h *Handler) handleSignUp(w http.ResponseWriter, r *http.Request) error {
 	payload := new(t.RegisterRequest)
 	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
 
-	user, err := h.store.GetUserByEmail(r.Context(), payload.Email)
-
+	// the password is checked first, as the outcome must not depend on
+	// whether the address is taken when accounts are hidden.
+	report, err := auth.ValidatePassword(payload.Password, payload.FirstName, payload.LastName, payload.Email)
 	if err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
 
-	if user != nil {
-		return u.ERROR(w, ge.EmailExists)
+	if !report.OK() {
+		return passwordPolicyError(w, report)
 	}
 
-	err = h.store.Create(r.Context(), *payload)
+	start := time.Now()
+	user, err := h.store.GetUserByEmail(r.Context(), payload.Email)
 
 	if err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
 
-	return u.JSON(w, http.StatusOK, map[string]interface{}{
+	if user != nil {
+		err = h.audit(r, t.AuditEvent{
+			Type:     EventSignUp,
+			Outcome:  OutcomeFailure,
+			Reason:   "email_exists",
+			TargetID: user.ID.Hex(),
+			Email:    payload.Email,
+		})
+
+		if err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+
+		if !config.Envs.HideAccounts {
+			return u.ERROR(w, ge.EmailExists)
+		}
+
+		err = h.sendEmail(r, user, "account-exists", mail.Data{
+			"Link": mail.Link("/sign-in", nil),
+		})
+
+		if err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+	}
+
+	response := map[string]interface{}{
 		"message": "User successfully created",
-	})
+	}
+
+	if user == nil {
+		sent, cerr := h.createUser(r, *payload)
+		if cerr != nil {
+			return u.ERROR(w, cerr)
+		}
+
+		// telling the client to resend would reveal the account was new.
+		if !sent && !config.Envs.HideAccounts {
+			response["verificationSent"] = false
+		}
+	}
+
+	if config.Envs.HideAccounts {
+		padResponse(r, start)
+	}
+
+	return u.JSON(w, http.StatusOK, withPasswordWarnings(response, report))
+}
+
+// createUser stores a new account and sends its verification email. The
+// account exists once it is stored, so a failed email is only reported as not
+// sent; the client can ask for it again from verify-email/resend.
+func (h *Handler) createUser(r *http.Request, payload t.RegisterRequest) (bool, *ge.CustomError) {
+	if err := h.store.Create(r.Context(), payload); err != nil {
+		return false, ge.Internal
+	}
+
+	user, err := h.store.GetUserByEmail(r.Context(), payload.Email)
+
+	if err != nil || user == nil {
+		return false, ge.Internal
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventSignUp, ActorID: user.ID.Hex()}); err != nil {
+		return false, ge.Internal
+	}
+
+	if cerr := h.sendVerification(r, user); cerr != nil {
+		log.Printf("sending verification email to new user %s failed: %v", user.ID.Hex(), cerr)
+		return false, nil
+	}
+
+	return true, nil
 }
 
 func (h *Handler) handleSelf(w http.ResponseWriter, r *http.Request) error {
@@ -76,6 +210,10 @@ func (h *Handler) handleSelf(w http.ResponseWriter, r *http.Request) error {
 		return u.ERROR(w, ge.Internal)
 	}
 
+	if user != nil {
+		user.Security.RecoveryCodesRemaining = len(user.Security.RecoveryCodes)
+	}
+
 	return u.JSON(w, http.StatusOK, map[string]interface{}{
 		"results": []*t.User{user},
 	})
@@ -87,6 +225,20 @@ func (h *Handler) handleSignIn(w http.ResponseWriter, r *http.Request) error {
 		return u.ERROR(w, ge.Internal)
 	}
 
+	lockedUntil, err := h.checkLockout(r, payload.Email)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !lockedUntil.IsZero() {
+		err = h.audit(r, t.AuditEvent{Type: EventSignIn, Outcome: OutcomeFailure, Reason: "locked", Email: payload.Email})
+		if err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+		return lockedOut(w, lockedUntil)
+	}
+
 	user, err := h.store.GetUserByEmail(r.Context(), payload.Email)
 
 	if err != nil {
@@ -94,14 +246,112 @@ func (h *Handler) handleSignIn(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if user == nil || user.Meta.IsArchived {
+		if config.Envs.HideAccounts {
+			auth.CompareDummyPassword([]byte(payload.Password))
+		}
+
+		reason := "unknown_user"
+		if user != nil {
+			reason = "archived"
+		}
+
+		if err := h.recordSignInFailure(r, payload.Email, user, reason); err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+
+		if config.Envs.HideAccounts {
+			return u.ERROR(w, ge.IncorrectCredentials)
+		}
 		return u.ERROR(w, ge.UserNotFound)
 	}
 
 	if !auth.ComparePasswords(user.Password, []byte(payload.Password)) {
+		if err := h.recordSignInFailure(r, payload.Email, user, "wrong_password"); err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
 		return u.ERROR(w, ge.IncorrectCredentials)
 	}
 
-	access, err := createAndSetAuthCookies(user.ID.Hex(), w)
+	if err := h.store.ClearLoginAttempts(r.Context(), accountLockKey(payload.Email)); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	// the plain password is only ever available here, so this is where hashes
+	// made with an older algorithm or cost are upgraded. The old hash still
+	// works, so a failed upgrade does not stop the sign-in.
+	if auth.PasswordNeedsRehash(user.Password) {
+		if err := h.rehashPassword(r, user, payload.Password); err != nil {
+			log.Printf("upgrading the password hash of user %s failed: %v", user.ID.Hex(), err)
+		}
+	}
+
+	methods, err := h.secondFactors(r.Context(), user)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if len(methods) > 0 {
+		return h.issueMFAChallenge(w, user, methods)
+	}
+
+	return h.startSession(w, r, user)
+}
+
+func (h *Handler) rehashPassword(r *http.Request, user *t.User, password string) error {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return h.store.RehashPassword(r.Context(), user.ID, user.Password, hash)
+}
+
+// startSession signs the user in on a new refresh family and records the
+// device it was signed in from. Every sign-in path ends here, so this is
+// where the "sign-in" email verification policy is enforced.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *t.User) error {
+	if config.Envs.VerifyEmail == VerifySignIn && !user.Security.EmailVerified {
+		err := h.audit(r, t.AuditEvent{
+			Type:    EventSignIn,
+			Outcome: OutcomeFailure,
+			Reason:  "email_not_verified",
+			ActorID: user.ID.Hex(),
+		})
+
+		if err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+		return u.ERROR(w, ge.EmailNotVerified)
+	}
+
+	// checked before the new session is stored, which would always match.
+	if err := h.alertNewDevice(r, user); err != nil {
+		log.Printf("sending new device alert to user %s failed: %v", user.ID.Hex(), err)
+	}
+
+	family := primitive.NewObjectID().Hex()
+	access, err := h.createAndSetAuthCookies(r.Context(), user.ID.Hex(), family, w)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	err = h.store.CreateSession(r.Context(), t.Session{
+		UserID:    user.ID.Hex(),
+		FamilyID:  family,
+		UserAgent: r.UserAgent(),
+		IP:        u.GetIPFromRequest(r),
+		CreatedAt: time.Now().UTC(),
+		LastSeen:  time.Now().UTC(),
+		ExpiresAt: time.Now().Add(auth.RefreshTokenTTL).UTC(),
+	})
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	err = h.audit(r, t.AuditEvent{Type: EventSignIn, ActorID: user.ID.Hex(), SessionID: family})
 
 	if err != nil {
 		return u.ERROR(w, ge.Internal)
@@ -113,19 +363,94 @@ func (h *Handler) handleSignIn(w http.ResponseWriter, r *http.Request) error {
 	})
 }
 
+// alertNewDevice emails the user when none of their sessions was started from
+// the request's IP address and user agent.
+func (h *Handler) alertNewDevice(r *http.Request, user *t.User) error {
+	sessions, err := h.store.GetSessions(r.Context(), user.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	ip := u.GetIPFromRequest(r)
+	for _, s := range sessions {
+		if s.IP == ip && s.UserAgent == r.UserAgent() {
+			return nil
+		}
+	}
+
+	return h.sendEmail(r, user, "new-device", mail.Data{
+		"Time":      time.Now().UTC().Format(time.RFC1123),
+		"IP":        ip,
+		"UserAgent": r.UserAgent(),
+		"Link":      mail.Link("/reset-password", nil),
+	})
+}
+
 func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) error {
 	cookie, err := r.Cookie("refresh")
 	if err != nil {
 		return u.ERROR(w, ge.Unauthorized)
 	}
 
-	refresh, err := auth.ValidateJWT(cookie.Value)
+	refresh, err := auth.ValidateJWT(cookie.Value, auth.RefreshToken)
+	if errors.Is(err, auth.ErrTokenType) {
+		return u.ERROR(w, ge.WrongTokenType)
+	}
 	if err != nil || !refresh.Valid {
 		return u.ERROR(w, ge.Internal)
 	}
 
-	uid := auth.ReadJWT(refresh)
-	access, err := createAndSetAuthCookies(uid, w)
+	claims := auth.ReadClaims(refresh)
+	record, err := h.store.GetRefreshToken(r.Context(), claims.Id)
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if record == nil || record.UserID != claims.Subject {
+		return u.ERROR(w, ge.Unauthorized)
+	}
+
+	consumed, err := h.store.ConsumeRefreshToken(r.Context(), record.ID)
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	// a rotated or revoked token being presented again means it was stolen, so
+	// every token descended from the same sign-in is revoked.
+	if !consumed {
+		if err := h.endSession(r.Context(), record.FamilyID); err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+
+		err = h.audit(r, t.AuditEvent{
+			Type:      EventRefreshReuse,
+			Outcome:   OutcomeFailure,
+			TargetID:  record.UserID,
+			SessionID: record.FamilyID,
+		})
+		if err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+
+		clearAuthCookies(w)
+		return u.ERROR(w, ge.RefreshReused)
+	}
+
+	access, err := h.createAndSetAuthCookies(r.Context(), record.UserID, record.FamilyID, w)
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	err = h.store.TouchSession(r.Context(), record.FamilyID, u.GetIPFromRequest(r), r.UserAgent())
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	err = h.audit(r, t.AuditEvent{
+		Type:      EventRefresh,
+		ActorID:   record.UserID,
+		SessionID: record.FamilyID,
+	})
 	if err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
@@ -136,50 +461,89 @@ func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (h *Handler) handlePreResetPassword(w http.ResponseWriter, r *http.Request) error {
-	/*********************************
-	TODO: Send email via sendgrid/mailgun with url to reset via the token geneated in this request.
-	*********************************/
 	payload := new(t.ResetPasswordRequest)
 	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
 
+	start := time.Now()
 	user, err := h.store.GetUserByEmail(r.Context(), payload.Email)
 
 	if err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
 
-	if user == nil {
-		return u.ERROR(w, ge.UserNotFound)
+	event := t.AuditEvent{Type: EventPasswordResetRequest, Email: payload.Email}
+	if user != nil {
+		event.TargetID = user.ID.Hex()
+	} else {
+		event.Outcome, event.Reason = OutcomeFailure, "unknown_user"
 	}
 
-	token, err := auth.CreateJWT(payload.Email, time.Now().Add(time.Minute*5).UTC().Unix())
-	if err != nil {
+	if err := h.audit(r, event); err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
 
-	// for development purposes we'll log the token and put it in manually to save credits on sendgrid.
-	fmt.Println("Token: ", token)
+	if user == nil && !config.Envs.HideAccounts {
+		return u.ERROR(w, ge.UserNotFound)
+	}
+
+	if user != nil {
+		if err := h.sendPasswordReset(r, user); err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+	}
+
+	if config.Envs.HideAccounts {
+		padResponse(r, start)
+	}
 
 	return u.JSON(w, http.StatusOK, map[string]interface{}{
 		"message": fmt.Sprintf("Password reset email sent to %s", payload.Email),
 	})
 }
 
+func (h *Handler) sendPasswordReset(r *http.Request, user *t.User) error {
+	token, hash, err := auth.GenerateResetToken()
+	if err != nil {
+		return err
+	}
+
+	err = h.store.CreatePasswordReset(r.Context(), t.PasswordReset{
+		ID:        hash,
+		UserID:    user.ID.Hex(),
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: time.Now().Add(auth.ResetTokenTTL).UTC(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return h.sendEmail(r, user, "reset", mail.Data{
+		"Link":    mail.Link("/reset-password", url.Values{"token": {token}}),
+		"Minutes": int(auth.ResetTokenTTL.Minutes()),
+	})
+}
+
 func (h *Handler) handleConfirmResetPassword(w http.ResponseWriter, r *http.Request) error {
 	payload := new(t.ConfirmResetPasswordRequest)
 	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
 
-	token, err := auth.ValidateJWT(payload.Token)
-	if err != nil || !token.Valid {
+	hash := auth.HashResetToken(payload.Token)
+	reset, err := h.store.GetPasswordReset(r.Context(), hash)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if reset == nil {
 		return u.ERROR(w, ge.ResetExpired)
 	}
 
-	email := auth.ReadJWT(token)
-	user, err := h.store.GetUserByEmail(r.Context(), email)
+	user, err := h.store.GetUserByID(r.Context(), reset.UserID)
 
 	if err != nil {
 		return u.ERROR(w, ge.Internal)
@@ -189,15 +553,40 @@ func (h *Handler) handleConfirmResetPassword(w http.ResponseWriter, r *http.Requ
 		return u.ERROR(w, ge.UserNotFound)
 	}
 
+	// checked before the reset is consumed so a rejected password does not
+	// cost the user their link.
+	report, err := validateNewPassword(user, payload.Password)
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !report.OK() {
+		return passwordPolicyError(w, report)
+	}
+
+	reset, err = h.store.ConsumePasswordReset(r.Context(), hash)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if reset == nil {
+		return u.ERROR(w, ge.ResetExpired)
+	}
+
 	err = h.store.UpdatePassword(r.Context(), user.ID, payload.Password)
 
 	if err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
 
-	return u.JSON(w, http.StatusOK, map[string]interface{}{
+	if err := h.audit(r, t.AuditEvent{Type: EventPasswordReset, ActorID: user.ID.Hex()}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, withPasswordWarnings(map[string]interface{}{
 		"message": "Password successfully changed",
-	})
+	}, report))
 }
 
 func (h *Handler) handleUpdateUser(w http.ResponseWriter, r *http.Request) error {
@@ -207,13 +596,61 @@ func (h *Handler) handleUpdateUser(w http.ResponseWriter, r *http.Request) error
 	}
 
 	payload.ID = r.Context().Value("uid").(string)
+	user, err := h.store.GetUserByID(r.Context(), payload.ID)
+
+	if err != nil || user == nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	// a taken address refuses the whole request, before anything is saved,
+	// unless accounts are hidden. Then its owner is told instead and the
+	// response looks like any other email change.
+	start := time.Now()
+	changeEmail := payload.Email != "" && payload.Email != user.Email
+	var existing *t.User
+	if changeEmail {
+		existing, err = h.store.GetUserByEmail(r.Context(), payload.Email)
+
+		if err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+
+		if existing != nil && !config.Envs.HideAccounts {
+			return u.ERROR(w, ge.EmailExists)
+		}
+	}
 
-	err := h.store.UpdateUser(r.Context(), *payload)
+	err = h.store.UpdateUser(r.Context(), *payload)
 
 	if err != nil {
 		return u.ERROR(w, ge.Internal)
 	}
 
+	if err := h.audit(r, t.AuditEvent{Type: EventProfileUpdate}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	// the email is never written here; a change has to be confirmed from the
+	// new address first.
+	if changeEmail {
+		if existing != nil {
+			err = h.refuseEmailChange(r, existing, payload.Email)
+			if err != nil {
+				return u.ERROR(w, ge.Internal)
+			}
+		} else if cerr := h.startEmailChange(r, user, payload.Email); cerr != nil {
+			return u.ERROR(w, cerr)
+		}
+
+		if config.Envs.HideAccounts {
+			padResponse(r, start)
+		}
+
+		return u.JSON(w, http.StatusOK, map[string]interface{}{
+			"message": fmt.Sprintf("Sucessfully updated user, a link to confirm the new email address was sent to %s", payload.Email),
+		})
+	}
+
 	return u.JSON(w, http.StatusOK, map[string]interface{}{
 		"message": "Sucessfully updated user",
 	})
@@ -227,14 +664,11 @@ func (h *Handler) handleArchiveUser(w http.ResponseWriter, r *http.Request) erro
 		return u.ERROR(w, ge.Internal)
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refresh",
-		Value:    "",
-		Path:     "/users/user/refresh",
-		MaxAge:   -1,
-		Secure:   true,
-		HttpOnly: true,
-	})
+	if err := h.audit(r, t.AuditEvent{Type: EventAccountArchive}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	clearAuthCookies(w)
 
 	return u.JSON(w, http.StatusOK, map[string]interface{}{
 		"message":    "User successfully archived",
@@ -242,14 +676,81 @@ func (h *Handler) handleArchiveUser(w http.ResponseWriter, r *http.Request) erro
 	})
 }
 
-func createAndSetAuthCookies(uid string, w http.ResponseWriter) (string, error) {
-	access, err := auth.CreateJWT(uid, time.Now().Add(time.Minute*5).UTC().Unix())
+func (h *Handler) handleSignOut(w http.ResponseWriter, r *http.Request) error {
+	claims := r.Context().Value("claims").(*auth.Claims)
+
+	if err := auth.RevokeToken(r.Context(), claims); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if claims.SessionID != "" {
+		if err := h.endSession(r.Context(), claims.SessionID); err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventSignOut}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	clearAuthCookies(w)
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Successfully signed out",
+	})
+}
+
+func (h *Handler) handleSignOutAll(w http.ResponseWriter, r *http.Request) error {
+	uid := r.Context().Value("uid").(string)
+
+	if err := h.endAllSessions(r.Context(), uid); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventSignOutAll}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	clearAuthCookies(w)
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Successfully signed out of every session",
+	})
+}
+
+// createAndSetAuthCookies issues an access token and a tracked refresh token
+// belonging to the refresh family of the current sign-in.
+func (h *Handler) createAndSetAuthCookies(ctx context.Context, uid string, family string, w http.ResponseWriter) (string, error) {
+	accessClaims, err := auth.NewClaims(auth.AccessToken, uid, time.Now().Add(auth.AccessTokenTTL).UTC().Unix())
+	if err != nil {
+		return "", err
+	}
+	accessClaims.SessionID = family
+	access, err := auth.SignClaims(accessClaims)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(auth.RefreshTokenTTL).UTC()
+	claims, err := auth.NewClaims(auth.RefreshToken, uid, expiresAt.Unix())
+	if err != nil {
+		return "", err
+	}
+	claims.SessionID = family
+	refresh, err := auth.SignClaims(claims)
 	if err != nil {
-		return "", u.ERROR(w, ge.Internal)
+		return "", err
 	}
-	refresh, err := auth.CreateJWT(uid, time.Now().Add(time.Hour*24*7).UTC().Unix())
+
+	err = h.store.CreateRefreshToken(ctx, t.RefreshToken{
+		ID:        claims.Id,
+		FamilyID:  family,
+		UserID:    uid,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	})
 	if err != nil {
-		return "", u.ERROR(w, ge.Internal)
+		return "", err
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -262,3 +763,111 @@ func createAndSetAuthCookies(uid string, w http.ResponseWriter) (string, error)
 
 	return access, nil
 }
+
+// endSession revokes the refresh family bound to a session along with any
+// access tokens issued to it, and forgets the session.
+func (h *Handler) endSession(ctx context.Context, family string) error {
+	if err := h.store.RevokeRefreshFamily(ctx, family); err != nil {
+		return err
+	}
+
+	if err := auth.RevokeSessionTokens(ctx, family); err != nil {
+		return err
+	}
+
+	return h.store.DeleteSession(ctx, family)
+}
+
+// passwordPolicyError rejects a new password, listing every rule it broke.
+func passwordPolicyError(w http.ResponseWriter, report auth.PasswordReport) error {
+	return u.JSON(w, ge.PasswordPolicy.StatusCode, map[string]interface{}{
+		"message":    ge.PasswordPolicy.Message,
+		"violations": report.Violations,
+		"warnings":   report.Warnings,
+	})
+}
+
+// withPasswordWarnings adds the warnings about an accepted password to body.
+func withPasswordWarnings(body map[string]interface{}, report auth.PasswordReport) map[string]interface{} {
+	if len(report.Warnings) > 0 {
+		body["warnings"] = report.Warnings
+	}
+	return body
+}
+
+// endAllSessions signs uid out everywhere, revoking every refresh and access
+// token issued to them.
+func (h *Handler) endAllSessions(ctx context.Context, uid string) error {
+	if err := h.store.RevokeUserRefreshTokens(ctx, uid); err != nil {
+		return err
+	}
+
+	if err := h.store.DeleteUserSessions(ctx, uid); err != nil {
+		return err
+	}
+
+	return auth.RevokeAllTokens(ctx, uid)
+}
+
+// endOtherSessions signs uid out of every session except keep.
+func (h *Handler) endOtherSessions(ctx context.Context, uid string, keep string) error {
+	sessions, err := h.store.GetSessions(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.FamilyID == keep {
+			continue
+		}
+		if err := h.endSession(ctx, session.FamilyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clearAuthCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh",
+		Value:    "",
+		Path:     "/users/user/refresh",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+	})
+}
+
+// sendEmail renders the email template name in the user's preferred language,
+// falling back to the request's Accept-Language, and sends it to the user.
+func (h *Handler) sendEmail(r *http.Request, user *t.User, name string, data mail.Data) error {
+	return h.sendEmailTo(r, user, user.Email, name, data)
+}
+
+// sendEmailTo is sendEmail for an address other than the user's current one.
+func (h *Handler) sendEmailTo(r *http.Request, user *t.User, to string, name string, data mail.Data) error {
+	data["Name"] = user.FirstName
+	msg, err := mail.Render(name, to, data, user.Language, r.Header.Get("Accept-Language"))
+
+	if err != nil {
+		return err
+	}
+
+	return h.mailer.Send(r.Context(), msg)
+}
+
+// uniformResponseTime is the least time taken by endpoints that do more work,
+// such as sending email, for existing accounts than for unknown addresses,
+// when accounts are hidden.
+const uniformResponseTime = 500 * time.Millisecond
+
+// padResponse waits until uniformResponseTime has passed since start.
+func padResponse(r *http.Request, start time.Time) {
+	timer := time.NewTimer(time.Until(start.Add(uniformResponseTime)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
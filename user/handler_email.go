@@ -0,0 +1,210 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	ge "github.com/findsam/food-server/error"
+	"github.com/findsam/food-server/mail"
+	t "github.com/findsam/food-server/types"
+	u "github.com/findsam/food-server/util"
+)
+
+// refuseEmailChange audits an email change to an address held by existing and
+// lets its owner know, for when accounts are hidden and the requester cannot
+// be told the address is taken.
+func (h *Handler) refuseEmailChange(r *http.Request, existing *t.User, email string) error {
+	err := h.audit(r, t.AuditEvent{
+		Type:     EventEmailChangeRequest,
+		Outcome:  OutcomeFailure,
+		Reason:   "email_exists",
+		TargetID: existing.ID.Hex(),
+		Email:    email,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return h.sendEmail(r, existing, "account-exists", mail.Data{
+		"Link": mail.Link("/sign-in", nil),
+	})
+}
+
+// startEmailChange records email as pending and sends a confirmation link to
+// it, along with a notice to the current address carrying a link to undo the
+// change in case the account was taken over. The caller checks that no other
+// user holds email.
+func (h *Handler) startEmailChange(r *http.Request, user *t.User, email string) *ge.CustomError {
+	if err := h.store.SetPendingEmail(r.Context(), user.ID, email); err != nil {
+		return ge.Internal
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventEmailChangeRequest, Email: email}); err != nil {
+		return ge.Internal
+	}
+
+	confirm, err := auth.NewClaims(auth.EmailChangeToken, user.ID.Hex(), time.Now().Add(auth.EmailChangeTokenTTL).UTC().Unix())
+	if err != nil {
+		return ge.Internal
+	}
+	confirm.Email = email
+	confirmToken, err := auth.SignClaims(confirm)
+	if err != nil {
+		return ge.Internal
+	}
+
+	revert, err := auth.NewClaims(auth.EmailRevertToken, user.ID.Hex(), time.Now().Add(auth.EmailRevertTokenTTL).UTC().Unix())
+	if err != nil {
+		return ge.Internal
+	}
+	revert.Email = user.Email
+	revertToken, err := auth.SignClaims(revert)
+	if err != nil {
+		return ge.Internal
+	}
+
+	err = h.sendEmailTo(r, user, email, "email-change", mail.Data{
+		"Link":  mail.Link("/confirm-email", url.Values{"token": {confirmToken}}),
+		"Hours": int(auth.EmailChangeTokenTTL.Hours()),
+	})
+
+	if err != nil {
+		return ge.Internal
+	}
+
+	err = h.sendEmail(r, user, "email-changed", mail.Data{
+		"Link":     mail.Link("/revert-email", url.Values{"token": {revertToken}}),
+		"NewEmail": email,
+		"Days":     int(auth.EmailRevertTokenTTL.Hours() / 24),
+	})
+
+	if err != nil {
+		return ge.Internal
+	}
+
+	return nil
+}
+
+func (h *Handler) handleConfirmEmailChange(w http.ResponseWriter, r *http.Request) error {
+	payload := new(t.EmailChangeRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	claims, user, cerr := h.readEmailChangeToken(r.Context(), payload.Token, auth.EmailChangeToken)
+	if cerr != nil {
+		return u.ERROR(w, cerr)
+	}
+
+	changed, err := h.store.ConfirmEmailChange(r.Context(), user.ID, claims.Email)
+
+	if errors.Is(err, ErrEmailExists) {
+		return u.ERROR(w, ge.EmailExists)
+	}
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	// a newer change replaced the one this link was sent for.
+	if !changed {
+		return u.ERROR(w, ge.EmailChangeExpired)
+	}
+
+	err = h.audit(r, t.AuditEvent{Type: EventEmailChange, ActorID: user.ID.Hex(), Email: claims.Email})
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := auth.RevokeToken(r.Context(), claims); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Email address successfully changed",
+	})
+}
+
+// handleRevertEmailChange puts the old address back and, since the change was
+// not wanted, signs the account out everywhere.
+func (h *Handler) handleRevertEmailChange(w http.ResponseWriter, r *http.Request) error {
+	payload := new(t.EmailChangeRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	claims, user, cerr := h.readEmailChangeToken(r.Context(), payload.Token, auth.EmailRevertToken)
+	if cerr != nil {
+		return u.ERROR(w, cerr)
+	}
+
+	err := h.store.RevertEmailChange(r.Context(), user.ID, claims.Email)
+
+	if errors.Is(err, ErrEmailExists) {
+		return u.ERROR(w, ge.EmailExists)
+	}
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := h.endAllSessions(r.Context(), user.ID.Hex()); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	err = h.audit(r, t.AuditEvent{Type: EventEmailRevert, ActorID: user.ID.Hex(), Email: claims.Email})
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := auth.RevokeToken(r.Context(), claims); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Email address successfully restored, every session has been signed out",
+	})
+}
+
+// readEmailChangeToken validates a single use email change token of type typ
+// and loads the user it was issued to.
+func (h *Handler) readEmailChangeToken(ctx context.Context, tokenString string, typ auth.TokenType) (*auth.Claims, *t.User, *ge.CustomError) {
+	token, err := auth.ValidateJWT(tokenString, typ)
+	if errors.Is(err, auth.ErrTokenType) {
+		return nil, nil, ge.WrongTokenType
+	}
+	if err != nil || !token.Valid {
+		return nil, nil, ge.EmailChangeExpired
+	}
+
+	claims := auth.ReadClaims(token)
+	revoked, err := auth.IsRevoked(ctx, claims)
+
+	if err != nil {
+		return nil, nil, ge.Internal
+	}
+
+	if revoked || claims.Email == "" {
+		return nil, nil, ge.EmailChangeExpired
+	}
+
+	user, err := h.store.GetUserByID(ctx, claims.Subject)
+
+	if err != nil {
+		return nil, nil, ge.Internal
+	}
+
+	if user == nil || user.Meta.IsArchived {
+		return nil, nil, ge.EmailChangeExpired
+	}
+
+	return claims, user, nil
+}
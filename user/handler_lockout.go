@@ -0,0 +1,176 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	"github.com/findsam/food-server/config"
+	ge "github.com/findsam/food-server/error"
+	"github.com/findsam/food-server/mail"
+	t "github.com/findsam/food-server/types"
+	u "github.com/findsam/food-server/util"
+)
+
+// Failed sign-ins are counted under both keys, so guessing many passwords for
+// one account and one password for many accounts are both slowed down.
+func accountLockKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLockKey(ip string) string {
+	return "ip:" + ip
+}
+
+// checkLockout reports the time until which sign-in is refused for email or
+// from the request's IP address, or the zero time when it is allowed.
+func (h *Handler) checkLockout(r *http.Request, email string) (time.Time, error) {
+	attempts, err := h.store.GetLoginAttempts(r.Context(), accountLockKey(email), ipLockKey(u.GetIPFromRequest(r)))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var until time.Time
+	for _, a := range attempts {
+		if a.LockedUntil.After(until) {
+			until = a.LockedUntil
+		}
+	}
+
+	if !until.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+// recordSignInFailure audits and counts a failed sign-in and applies any
+// delay or lockout it triggers. When the account itself becomes locked, its
+// owner is emailed a link to unlock it.
+func (h *Handler) recordSignInFailure(r *http.Request, email string, user *t.User, reason string) error {
+	event := t.AuditEvent{Type: EventSignIn, Outcome: OutcomeFailure, Reason: reason, Email: email}
+	if user != nil {
+		event.TargetID = user.ID.Hex()
+	}
+
+	if err := h.audit(r, event); err != nil {
+		return err
+	}
+
+	account := accountLockKey(email)
+	counters := []struct {
+		key    string
+		policy auth.LockoutPolicy
+	}{
+		{account, auth.AccountLockoutPolicy()},
+		{ipLockKey(u.GetIPFromRequest(r)), auth.IPLockoutPolicy()},
+	}
+
+	for _, c := range counters {
+		failures, err := h.store.RecordLoginFailure(r.Context(), c.key, config.Envs.Lockout.Window)
+		if err != nil {
+			return err
+		}
+
+		delay := c.policy.LockFor(failures)
+		if delay == 0 {
+			continue
+		}
+
+		if err := h.store.LockLogin(r.Context(), c.key, time.Now().Add(delay).UTC()); err != nil {
+			return err
+		}
+
+		// only the failure that starts the lockout is audited and emailed.
+		if c.key != account || failures != c.policy.LockAfter {
+			continue
+		}
+
+		event.Type, event.Outcome, event.Reason = EventAccountLock, OutcomeSuccess, ""
+		if err := h.audit(r, event); err != nil {
+			return err
+		}
+
+		if user != nil && !user.Meta.IsArchived {
+			if err := h.sendUnlock(r, user); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Handler) sendUnlock(r *http.Request, user *t.User) error {
+	token, err := auth.CreateJWT(auth.UnlockToken, user.ID.Hex(), time.Now().Add(auth.UnlockTokenTTL).UTC().Unix())
+	if err != nil {
+		return err
+	}
+
+	return h.sendEmail(r, user, "unlock", mail.Data{
+		"Link":    mail.Link("/unlock", url.Values{"token": {token}}),
+		"Minutes": int(config.Envs.Lockout.LockDuration.Minutes()),
+	})
+}
+
+// lockedOut refuses a sign-in attempt, telling the client when to retry.
+func lockedOut(w http.ResponseWriter, until time.Time) error {
+	retry := int(time.Until(until).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	return u.ERROR(w, ge.AccountLocked)
+}
+
+func (h *Handler) handleUnlock(w http.ResponseWriter, r *http.Request) error {
+	payload := new(t.UnlockRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	token, err := auth.ValidateJWT(payload.Token, auth.UnlockToken)
+	if errors.Is(err, auth.ErrTokenType) {
+		return u.ERROR(w, ge.WrongTokenType)
+	}
+	if err != nil || !token.Valid {
+		return u.ERROR(w, ge.UnlockExpired)
+	}
+
+	claims := auth.ReadClaims(token)
+	revoked, err := auth.IsRevoked(r.Context(), claims)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if revoked {
+		return u.ERROR(w, ge.UnlockExpired)
+	}
+
+	user, err := h.store.GetUserByID(r.Context(), claims.Subject)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if user == nil {
+		return u.ERROR(w, ge.UnlockExpired)
+	}
+
+	if err := h.store.ClearLoginAttempts(r.Context(), accountLockKey(user.Email)); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventAccountUnlock, ActorID: user.ID.Hex()}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := auth.RevokeToken(r.Context(), claims); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Account successfully unlocked",
+	})
+}
@@ -0,0 +1,326 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	ge "github.com/findsam/food-server/error"
+	t "github.com/findsam/food-server/types"
+	u "github.com/findsam/food-server/util"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+	ceremonyMFA      = "mfa"
+)
+
+func (h *Handler) handleGetPasskeys(w http.ResponseWriter, r *http.Request) error {
+	uid := r.Context().Value("uid").(string)
+	passkeys, err := h.store.GetPasskeys(r.Context(), uid)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"results": passkeys,
+	})
+}
+
+func (h *Handler) handleDeletePasskey(w http.ResponseWriter, r *http.Request) error {
+	uid := r.Context().Value("uid").(string)
+	deleted, err := h.store.DeletePasskey(r.Context(), uid, chi.URLParam(r, "id"))
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !deleted {
+		return u.ERROR(w, ge.NotFound)
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventPasskeyRemove}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Passkey successfully removed",
+	})
+}
+
+func (h *Handler) handleBeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) error {
+	uid := r.Context().Value("uid").(string)
+	pu, err := h.passkeyUser(r.Context(), uid)
+
+	if err != nil || pu == nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	creation, data, err := h.passkeys.BeginRegistration(pu, webauthn.WithExclusions(pu.Exclusions()))
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return h.beginCeremony(w, r, t.PasskeyCeremony{Purpose: ceremonyRegister, UserID: uid, Data: *data}, creation)
+}
+
+func (h *Handler) handleFinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) error {
+	uid := r.Context().Value("uid").(string)
+	ceremony, err := h.store.TakePasskeyCeremony(r.Context(), r.URL.Query().Get("session"))
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if ceremony == nil || ceremony.Purpose != ceremonyRegister || ceremony.UserID != uid {
+		return u.ERROR(w, ge.PasskeyRejected)
+	}
+
+	pu, err := h.passkeyUser(r.Context(), uid)
+
+	if err != nil || pu == nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	cred, err := h.passkeys.FinishRegistration(pu, ceremony.Data, r)
+	if err != nil {
+		return u.ERROR(w, ge.PasskeyRejected)
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Passkey"
+	}
+
+	err = h.store.CreatePasskey(r.Context(), t.Passkey{
+		UserID:     uid,
+		Name:       name,
+		Credential: *cred,
+		CreatedAt:  time.Now().UTC(),
+	})
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventPasskeyAdd}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Passkey successfully registered",
+	})
+}
+
+// handleBeginPasskeyLogin requires user verification, a PIN or biometric,
+// because the passkey alone stands in for the password and second factor.
+func (h *Handler) handleBeginPasskeyLogin(w http.ResponseWriter, r *http.Request) error {
+	assertion, data, err := h.passkeys.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return h.beginCeremony(w, r, t.PasskeyCeremony{Purpose: ceremonyLogin, Data: *data}, assertion)
+}
+
+// handleFinishPasskeyLogin signs a user in with a discoverable credential
+// alone, standing in for both the password and the second factor.
+func (h *Handler) handleFinishPasskeyLogin(w http.ResponseWriter, r *http.Request) error {
+	ceremony, err := h.store.TakePasskeyCeremony(r.Context(), r.URL.Query().Get("session"))
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if ceremony == nil || ceremony.Purpose != ceremonyLogin {
+		return u.ERROR(w, ge.PasskeyRejected)
+	}
+
+	var pu *auth.PasskeyUser
+	cred, err := h.passkeys.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != len(primitive.ObjectID{}) {
+			return nil, ge.PasskeyRejected
+		}
+
+		found, err := h.passkeyUser(r.Context(), primitive.ObjectID(userHandle).Hex())
+		if err != nil || found == nil {
+			return nil, ge.PasskeyRejected
+		}
+		pu = found
+		return pu, nil
+	}, ceremony.Data, r)
+
+	if err != nil || pu == nil || pu.User.Meta.IsArchived {
+		return u.ERROR(w, ge.PasskeyRejected)
+	}
+
+	// presence alone, such as a tap on an unattended authenticator, is not
+	// enough without a password.
+	if !cred.Flags.UserVerified {
+		return u.ERROR(w, ge.PasskeyNotVerified)
+	}
+
+	if cerr := h.recordPasskeyUse(r.Context(), pu, cred); cerr != nil {
+		return u.ERROR(w, cerr)
+	}
+
+	return h.startSession(w, r, pu.User)
+}
+
+func (h *Handler) handleBeginPasskeyMFA(w http.ResponseWriter, r *http.Request) error {
+	payload := new(t.PasskeyChallengeRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	claims, cerr := readMFAChallenge(r.Context(), payload.Challenge)
+	if cerr != nil {
+		return u.ERROR(w, cerr)
+	}
+
+	pu, err := h.passkeyUser(r.Context(), claims.Subject)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if pu == nil || len(pu.Passkeys) == 0 {
+		return u.ERROR(w, ge.PasskeyRejected)
+	}
+
+	assertion, data, err := h.passkeys.BeginLogin(pu)
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return h.beginCeremony(w, r, t.PasskeyCeremony{
+		Purpose:   ceremonyMFA,
+		UserID:    claims.Subject,
+		Challenge: payload.Challenge,
+		Data:      *data,
+	}, assertion)
+}
+
+func (h *Handler) handleFinishPasskeyMFA(w http.ResponseWriter, r *http.Request) error {
+	ceremony, err := h.store.TakePasskeyCeremony(r.Context(), r.URL.Query().Get("session"))
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if ceremony == nil || ceremony.Purpose != ceremonyMFA {
+		return u.ERROR(w, ge.PasskeyRejected)
+	}
+
+	// several ceremonies can be begun from one challenge, but only the first
+	// to finish may use it.
+	claims, cerr := readMFAChallenge(r.Context(), ceremony.Challenge)
+	if cerr != nil {
+		return u.ERROR(w, cerr)
+	}
+
+	pu, err := h.passkeyUser(r.Context(), ceremony.UserID)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if pu == nil || pu.User.Meta.IsArchived {
+		return u.ERROR(w, ge.PasskeyRejected)
+	}
+
+	lockedUntil, err := h.checkLockout(r, pu.User.Email)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !lockedUntil.IsZero() {
+		return lockedOut(w, lockedUntil)
+	}
+
+	cred, err := h.passkeys.FinishLogin(pu, ceremony.Data, r)
+	cerr = ge.PasskeyRejected
+	if err == nil {
+		cerr = h.recordPasskeyUse(r.Context(), pu, cred)
+	}
+
+	// a rejected assertion counts like a wrong TOTP code.
+	if cerr == ge.PasskeyRejected {
+		if err := h.recordSignInFailure(r, pu.User.Email, pu.User, "wrong_second_factor"); err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+
+		if err := h.recordChallengeFailure(r, claims); err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+	}
+
+	if cerr != nil {
+		return u.ERROR(w, cerr)
+	}
+
+	// the MFA challenge is single use, exactly like a TOTP verification.
+	if err := auth.RevokeToken(r.Context(), claims); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return h.startSession(w, r, pu.User)
+}
+
+func (h *Handler) beginCeremony(w http.ResponseWriter, r *http.Request, ceremony t.PasskeyCeremony, options interface{}) error {
+	ceremony.ID = primitive.NewObjectID().Hex()
+	ceremony.ExpiresAt = time.Now().Add(auth.PasskeyCeremonyTTL).UTC()
+
+	if err := h.store.CreatePasskeyCeremony(r.Context(), ceremony); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"session": ceremony.ID,
+		"options": options,
+	})
+}
+
+func (h *Handler) passkeyUser(ctx context.Context, uid string) (*auth.PasskeyUser, error) {
+	user, err := h.store.GetUserByID(ctx, uid)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	passkeys, err := h.store.GetPasskeys(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.PasskeyUser{User: user, Passkeys: passkeys}, nil
+}
+
+// recordPasskeyUse stores the new sign counter of the asserted credential and
+// refuses credentials whose counter went backwards, a sign of a cloned key.
+func (h *Handler) recordPasskeyUse(ctx context.Context, pu *auth.PasskeyUser, cred *webauthn.Credential) *ge.CustomError {
+	if cred.Authenticator.CloneWarning {
+		return ge.PasskeyRejected
+	}
+
+	for _, pk := range pu.Passkeys {
+		if bytes.Equal(pk.Credential.ID, cred.ID) {
+			if err := h.store.UpdatePasskeyCredential(ctx, pk.ID, *cred); err != nil {
+				return ge.Internal
+			}
+			return nil
+		}
+	}
+
+	return ge.PasskeyRejected
+}
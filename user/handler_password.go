@@ -0,0 +1,89 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	ge "github.com/findsam/food-server/error"
+	"github.com/findsam/food-server/mail"
+	t "github.com/findsam/food-server/types"
+	u "github.com/findsam/food-server/util"
+)
+
+// handleChangePassword replaces the password of a signed-in user who knows the
+// current one. The session making the change stays signed in; every other
+// session is ended.
+func (h *Handler) handleChangePassword(w http.ResponseWriter, r *http.Request) error {
+	payload := new(t.ChangePasswordRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	claims := r.Context().Value("claims").(*auth.Claims)
+	user, err := h.store.GetUserByID(r.Context(), claims.Subject)
+
+	if err != nil || user == nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !auth.ComparePasswords(user.Password, []byte(payload.CurrentPassword)) {
+		err := h.audit(r, t.AuditEvent{Type: EventPasswordChange, Outcome: OutcomeFailure, Reason: "wrong_password"})
+		if err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+		return u.ERROR(w, ge.IncorrectCredentials)
+	}
+
+	report, err := validateNewPassword(user, payload.NewPassword)
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !report.OK() {
+		return passwordPolicyError(w, report)
+	}
+
+	err = h.store.UpdatePassword(r.Context(), user.ID, payload.NewPassword)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := h.endOtherSessions(r.Context(), claims.Subject, claims.SessionID); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventPasswordChange}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	err = h.sendEmail(r, user, "password-changed", mail.Data{
+		"Time":      time.Now().UTC().Format(time.RFC1123),
+		"IP":        u.GetIPFromRequest(r),
+		"UserAgent": r.UserAgent(),
+		"Link":      mail.Link("/reset-password", nil),
+	})
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, withPasswordWarnings(map[string]interface{}{
+		"message": "Password successfully changed",
+	}, report))
+}
+
+// validateNewPassword applies the password policy to a password replacing the
+// user's current one. Neither it nor any password in their history may be
+// reused.
+func validateNewPassword(user *t.User, password string) (auth.PasswordReport, error) {
+	report, err := auth.ValidatePassword(password, user.FirstName, user.LastName, user.Email)
+	if err != nil || !report.OK() {
+		return report, err
+	}
+
+	report.CheckReuse(password, append([]string{user.Password}, user.PasswordHistory...)...)
+	return report, nil
+}
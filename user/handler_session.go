@@ -0,0 +1,58 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/findsam/food-server/auth"
+	ge "github.com/findsam/food-server/error"
+	t "github.com/findsam/food-server/types"
+	u "github.com/findsam/food-server/util"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) handleGetSessions(w http.ResponseWriter, r *http.Request) error {
+	claims := r.Context().Value("claims").(*auth.Claims)
+	sessions, err := h.store.GetSessions(r.Context(), claims.Subject)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].FamilyID == claims.SessionID
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"results": sessions,
+	})
+}
+
+func (h *Handler) handleRevokeSession(w http.ResponseWriter, r *http.Request) error {
+	claims := r.Context().Value("claims").(*auth.Claims)
+	session, err := h.store.GetSession(r.Context(), claims.Subject, chi.URLParam(r, "id"))
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if session == nil {
+		return u.ERROR(w, ge.NotFound)
+	}
+
+	if err := h.endSession(r.Context(), session.FamilyID); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventSessionRevoke, SessionID: session.FamilyID}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if session.FamilyID == claims.SessionID {
+		clearAuthCookies(w)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Session successfully revoked",
+	})
+}
@@ -0,0 +1,403 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	"github.com/findsam/food-server/config"
+	ge "github.com/findsam/food-server/error"
+	"github.com/findsam/food-server/mail"
+	"github.com/findsam/food-server/types"
+	u "github.com/findsam/food-server/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeStore keeps in memory the parts of the user store the tests exercise.
+// Calling any other method panics on the nil embedded interface.
+type fakeStore struct {
+	types.UserStore
+	users    map[string]*types.User
+	refresh  map[string]*types.RefreshToken
+	sessions map[string]types.Session
+	attempts map[string]*types.LoginAttempt
+	events   []types.AuditEvent
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		users:    map[string]*types.User{},
+		refresh:  map[string]*types.RefreshToken{},
+		sessions: map[string]types.Session{},
+		attempts: map[string]*types.LoginAttempt{},
+	}
+}
+
+func (s *fakeStore) addUser(email string) *types.User {
+	user := &types.User{ID: primitive.NewObjectID(), FirstName: "Sam", Email: email}
+	s.users[user.ID.Hex()] = user
+	return user
+}
+
+func (s *fakeStore) GetUserByID(ctx context.Context, id string) (*types.User, error) {
+	return s.users[id], nil
+}
+
+func (s *fakeStore) RevertEmailChange(ctx context.Context, uid primitive.ObjectID, email string) error {
+	for _, other := range s.users {
+		if other.Email == email && other.ID != uid {
+			return ErrEmailExists
+		}
+	}
+	s.users[uid.Hex()].Email = email
+	return nil
+}
+
+func (s *fakeStore) UseRecoveryCode(ctx context.Context, uid primitive.ObjectID, hash string) (bool, error) {
+	return false, nil
+}
+
+func (s *fakeStore) CreateRefreshToken(ctx context.Context, rt types.RefreshToken) error {
+	s.refresh[rt.ID] = &rt
+	return nil
+}
+
+func (s *fakeStore) GetRefreshToken(ctx context.Context, jti string) (*types.RefreshToken, error) {
+	return s.refresh[jti], nil
+}
+
+func (s *fakeStore) ConsumeRefreshToken(ctx context.Context, jti string) (bool, error) {
+	rt, ok := s.refresh[jti]
+	if !ok || !rt.RotatedAt.IsZero() || !rt.RevokedAt.IsZero() {
+		return false, nil
+	}
+	rt.RotatedAt = time.Now().UTC()
+	return true, nil
+}
+
+func (s *fakeStore) RevokeRefreshFamily(ctx context.Context, family string) error {
+	for _, rt := range s.refresh {
+		if rt.FamilyID == family && rt.RevokedAt.IsZero() {
+			rt.RevokedAt = time.Now().UTC()
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) RevokeUserRefreshTokens(ctx context.Context, uid string) error {
+	for _, rt := range s.refresh {
+		if rt.UserID == uid && rt.RevokedAt.IsZero() {
+			rt.RevokedAt = time.Now().UTC()
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) CreateSession(ctx context.Context, session types.Session) error {
+	s.sessions[session.FamilyID] = session
+	return nil
+}
+
+func (s *fakeStore) TouchSession(ctx context.Context, family string, ip string, userAgent string) error {
+	return nil
+}
+
+func (s *fakeStore) DeleteSession(ctx context.Context, family string) error {
+	delete(s.sessions, family)
+	return nil
+}
+
+func (s *fakeStore) DeleteUserSessions(ctx context.Context, uid string) error {
+	for family, session := range s.sessions {
+		if session.UserID == uid {
+			delete(s.sessions, family)
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) GetLoginAttempts(ctx context.Context, keys ...string) ([]types.LoginAttempt, error) {
+	attempts := []types.LoginAttempt{}
+	for _, key := range keys {
+		if a, ok := s.attempts[key]; ok {
+			attempts = append(attempts, *a)
+		}
+	}
+	return attempts, nil
+}
+
+func (s *fakeStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	a, ok := s.attempts[key]
+	if !ok {
+		a = &types.LoginAttempt{ID: key}
+		s.attempts[key] = a
+	}
+	a.Failures++
+	return a.Failures, nil
+}
+
+func (s *fakeStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	s.attempts[key].LockedUntil = until
+	return nil
+}
+
+func (s *fakeStore) RecordAuditEvent(ctx context.Context, e types.AuditEvent) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *fakeStore) hasEvent(typ string) bool {
+	for _, e := range s.events {
+		if e.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestHandler() (*Handler, *fakeStore, *mail.MemoryMailer) {
+	config.Envs.JWTSecret = "testsecret"
+
+	store := newFakeStore()
+	mailer := mail.NewMemoryMailer()
+	return NewHandler(store, nil, mailer, Limits{}), store, mailer
+}
+
+// signIn issues the cookies and session of a new sign-in for user, returning
+// the refresh cookie and access token.
+func signIn(t *testing.T, h *Handler, store *fakeStore, user *types.User) (*http.Cookie, string) {
+	family := primitive.NewObjectID().Hex()
+	rec := httptest.NewRecorder()
+
+	access, err := h.createAndSetAuthCookies(context.Background(), user.ID.Hex(), family, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.CreateSession(context.Background(), types.Session{UserID: user.ID.Hex(), FamilyID: family})
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "refresh" {
+			return c, access
+		}
+	}
+	t.Fatal("no refresh cookie was set")
+	return nil, ""
+}
+
+func isAccessRevoked(t *testing.T, access string) bool {
+	token, err := auth.ValidateJWT(access, auth.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := auth.IsRevoked(context.Background(), auth.ReadClaims(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return revoked
+}
+
+func refreshClaims(t *testing.T, refresh string) *auth.Claims {
+	token, err := auth.ValidateJWT(refresh, auth.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.ReadClaims(token)
+}
+
+func post(h func(http.ResponseWriter, *http.Request) error, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	rec := httptest.NewRecorder()
+	u.MakeHTTPHandlerFunc(h).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(b))))
+	return rec
+}
+
+func expectError(t *testing.T, rec *httptest.ResponseRecorder, want *ge.CustomError) {
+	t.Helper()
+	if rec.Code != want.StatusCode || !strings.Contains(rec.Body.String(), want.Message) {
+		t.Fatalf("got %d %s, want %d %q", rec.Code, rec.Body.String(), want.StatusCode, want.Message)
+	}
+}
+
+func TestRefresh_ReuseEndsSession(t *testing.T) {
+	h, store, _ := newTestHandler()
+	user := store.addUser("sam@example.com")
+
+	stolen, _ := signIn(t, h, store, user)
+	_, otherAccess := signIn(t, h, store, user)
+
+	refresh := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookie)
+
+		rec := httptest.NewRecorder()
+		u.MakeHTTPHandlerFunc(h.handleRefresh).ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := refresh(stolen)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first refresh: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	var body struct{ Token string }
+	json.NewDecoder(rec.Body).Decode(&body)
+
+	expectError(t, refresh(stolen), ge.RefreshReused)
+
+	family := store.refresh[refreshClaims(t, stolen.Value).Id].FamilyID
+	for _, rt := range store.refresh {
+		if rt.FamilyID == family && rt.RevokedAt.IsZero() {
+			t.Errorf("refresh token %s of the reused family was not revoked", rt.ID)
+		}
+	}
+
+	if _, ok := store.sessions[family]; ok {
+		t.Error("the session of the reused token was not ended")
+	}
+	if !isAccessRevoked(t, body.Token) {
+		t.Error("the access token issued by the rotation was not revoked")
+	}
+	if !store.hasEvent(EventRefreshReuse) {
+		t.Error("the reuse was not audited")
+	}
+
+	if len(store.sessions) != 1 || isAccessRevoked(t, otherAccess) {
+		t.Error("another sign-in of the same user was ended")
+	}
+}
+
+func TestVerifyTwoFactor_ChallengeFailures(t *testing.T) {
+	h, store, _ := newTestHandler()
+	user := store.addUser("sam@example.com")
+	user.Security.HasTwoFactor = true
+
+	original := config.Envs.Lockout
+	config.Envs.Lockout.AccountDelayAfter, config.Envs.Lockout.AccountLockAfter = 100, 100
+	defer func() { config.Envs.Lockout = original }()
+
+	challenge, err := auth.CreateJWT(auth.MFAToken, user.ID.Hex(), time.Now().Add(auth.MFATokenTTL).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := types.TwoFactorVerifyRequest{Challenge: challenge, RecoveryCode: "wrong"}
+
+	for i := 0; i < maxChallengeFailures; i++ {
+		expectError(t, post(h.handleVerifyTwoFactor, payload), ge.IncorrectTwoFactor)
+	}
+
+	expectError(t, post(h.handleVerifyTwoFactor, payload), ge.TokenRevoked)
+
+	if got := store.attempts[accountLockKey(user.Email)].Failures; got != maxChallengeFailures {
+		t.Errorf("counted %d failed sign-ins against the account, want %d", got, maxChallengeFailures)
+	}
+}
+
+func TestVerifyTwoFactor_Lockout(t *testing.T) {
+	h, store, mailer := newTestHandler()
+	user := store.addUser("sam@example.com")
+	user.Security.HasTwoFactor = true
+
+	original := config.Envs.Lockout
+	config.Envs.Lockout.AccountDelayAfter, config.Envs.Lockout.AccountLockAfter = 3, 3
+	defer func() { config.Envs.Lockout = original }()
+
+	// a fresh challenge per attempt, as if the password was entered again.
+	attempt := func() *httptest.ResponseRecorder {
+		challenge, err := auth.CreateJWT(auth.MFAToken, user.ID.Hex(), time.Now().Add(auth.MFATokenTTL).Unix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return post(h.handleVerifyTwoFactor, types.TwoFactorVerifyRequest{Challenge: challenge, RecoveryCode: "wrong"})
+	}
+
+	for i := 0; i < 3; i++ {
+		expectError(t, attempt(), ge.IncorrectTwoFactor)
+	}
+
+	rec := attempt()
+	expectError(t, rec, ge.AccountLocked)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("locked out response should carry Retry-After")
+	}
+
+	sent := mailer.Messages()
+	if len(sent) != 1 || sent[0].To != user.Email {
+		t.Errorf("expected one unlock email to %s, got %+v", user.Email, sent)
+	}
+	if !store.hasEvent(EventAccountLock) {
+		t.Error("the lockout was not audited")
+	}
+}
+
+func TestRevertEmailChange(t *testing.T) {
+	h, store, _ := newTestHandler()
+	user := store.addUser("attacker@example.com")
+
+	_, access := signIn(t, h, store, user)
+	signIn(t, h, store, user)
+	time.Sleep(2 * time.Millisecond)
+
+	claims, err := auth.NewClaims(auth.EmailRevertToken, user.ID.Hex(), time.Now().Add(auth.EmailRevertTokenTTL).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims.Email = "sam@example.com"
+	token, err := auth.SignClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := post(h.handleRevertEmailChange, types.EmailChangeRequest{Token: token})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if user.Email != "sam@example.com" {
+		t.Errorf("email is %s, want the old address back", user.Email)
+	}
+
+	if len(store.sessions) != 0 {
+		t.Errorf("%d sessions left after the revert", len(store.sessions))
+	}
+	for _, rt := range store.refresh {
+		if rt.RevokedAt.IsZero() {
+			t.Errorf("refresh token %s was not revoked", rt.ID)
+		}
+	}
+	if !isAccessRevoked(t, access) {
+		t.Error("an access token issued before the revert is still accepted")
+	}
+	if !store.hasEvent(EventEmailRevert) {
+		t.Error("the revert was not audited")
+	}
+
+	expectError(t, post(h.handleRevertEmailChange, types.EmailChangeRequest{Token: token}), ge.EmailChangeExpired)
+}
+
+func TestRevertEmailChange_AddressTaken(t *testing.T) {
+	h, store, _ := newTestHandler()
+	user := store.addUser("attacker@example.com")
+	store.addUser("sam@example.com")
+
+	claims, err := auth.NewClaims(auth.EmailRevertToken, user.ID.Hex(), time.Now().Add(auth.EmailRevertTokenTTL).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims.Email = "sam@example.com"
+	token, err := auth.SignClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectError(t, post(h.handleRevertEmailChange, types.EmailChangeRequest{Token: token}), ge.EmailExists)
+
+	if user.Email != "attacker@example.com" {
+		t.Errorf("email changed to %s although the address is taken", user.Email)
+	}
+}
@@ -0,0 +1,275 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	"github.com/findsam/food-server/config"
+	ge "github.com/findsam/food-server/error"
+	t "github.com/findsam/food-server/types"
+	u "github.com/findsam/food-server/util"
+)
+
+// maxChallengeFailures is how many wrong codes one MFA challenge accepts.
+const maxChallengeFailures = 5
+
+func (h *Handler) handleEnrollTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	uid := r.Context().Value("uid").(string)
+	user, err := h.store.GetUserByID(r.Context(), uid)
+
+	if err != nil || user == nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if user.Security.HasTwoFactor {
+		return u.ERROR(w, ge.TwoFactorEnabled)
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	err = h.store.SetPendingTwoFactor(r.Context(), user.ID, secret)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"secret": secret,
+		"uri":    auth.TOTPURI(secret, config.Envs.TOTPIssuer, user.Email),
+	})
+}
+
+func (h *Handler) handleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	payload := new(t.TwoFactorRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	uid := r.Context().Value("uid").(string)
+	user, err := h.store.GetUserByID(r.Context(), uid)
+
+	if err != nil || user == nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if user.Security.HasTwoFactor {
+		return u.ERROR(w, ge.TwoFactorEnabled)
+	}
+
+	if user.Security.TwoFactorPending == "" {
+		return u.ERROR(w, ge.TwoFactorNotPending)
+	}
+
+	step, ok := auth.ValidateTOTP(user.Security.TwoFactorPending, payload.Code, time.Now())
+	if !ok {
+		return u.ERROR(w, ge.IncorrectTwoFactor)
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	err = h.store.EnableTwoFactor(r.Context(), user.ID, user.Security.TwoFactorPending, step, hashes)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventTwoFactorEnable}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+func (h *Handler) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) error {
+	uid := r.Context().Value("uid").(string)
+	user, err := h.store.GetUserByID(r.Context(), uid)
+
+	if err != nil || user == nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !user.Security.HasTwoFactor {
+		return u.ERROR(w, ge.TwoFactorDisabled)
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	err = h.store.SetRecoveryCodes(r.Context(), user.ID, hashes)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := h.audit(r, t.AuditEvent{Type: EventRecoveryCodes}); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"recoveryCodes": codes,
+	})
+}
+
+// secondFactors lists the ways the user can answer an MFA challenge. An empty
+// list means a correct password is enough to sign in.
+func (h *Handler) secondFactors(ctx context.Context, user *t.User) ([]string, error) {
+	methods := []string{}
+	if user.Security.HasTwoFactor {
+		methods = append(methods, "totp")
+		if len(user.Security.RecoveryCodes) > 0 {
+			methods = append(methods, "recoveryCode")
+		}
+	}
+
+	passkeys, err := h.store.GetPasskeys(ctx, user.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(passkeys) > 0 {
+		methods = append(methods, "passkey")
+	}
+
+	return methods, nil
+}
+
+// issueMFAChallenge answers a correct password with a short-lived challenge
+// instead of credentials when the user has a second factor enabled.
+func (h *Handler) issueMFAChallenge(w http.ResponseWriter, user *t.User, methods []string) error {
+	challenge, err := auth.CreateJWT(auth.MFAToken, user.ID.Hex(), time.Now().Add(auth.MFATokenTTL).UTC().Unix())
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"mfaRequired": true,
+		"challenge":   challenge,
+		"methods":     methods,
+	})
+}
+
+func (h *Handler) handleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	payload := new(t.TwoFactorVerifyRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	claims, cerr := readMFAChallenge(r.Context(), payload.Challenge)
+	if cerr != nil {
+		return u.ERROR(w, cerr)
+	}
+
+	user, err := h.store.GetUserByID(r.Context(), claims.Subject)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if user == nil || user.Meta.IsArchived || !user.Security.HasTwoFactor {
+		return u.ERROR(w, ge.Unauthorized)
+	}
+
+	lockedUntil, err := h.checkLockout(r, user.Email)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !lockedUntil.IsZero() {
+		return lockedOut(w, lockedUntil)
+	}
+
+	fresh, err := h.checkSecondFactor(r, user, payload)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !fresh {
+		if err := h.recordSignInFailure(r, user.Email, user, "wrong_second_factor"); err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+
+		if err := h.recordChallengeFailure(r, claims); err != nil {
+			return u.ERROR(w, ge.Internal)
+		}
+		return u.ERROR(w, ge.IncorrectTwoFactor)
+	}
+
+	// the challenge is single use so it cannot be replayed with a later code.
+	if err := auth.RevokeToken(r.Context(), claims); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return h.startSession(w, r, user)
+}
+
+// recordChallengeFailure counts a wrong code against the challenge it was
+// given for and revokes the challenge after maxChallengeFailures, so the
+// password has to be entered again before guessing can go on.
+func (h *Handler) recordChallengeFailure(r *http.Request, claims *auth.Claims) error {
+	failures, err := h.store.RecordLoginFailure(r.Context(), "mfa:"+claims.Id, auth.MFATokenTTL)
+	if err != nil {
+		return err
+	}
+
+	if failures < maxChallengeFailures {
+		return nil
+	}
+	return auth.RevokeToken(r.Context(), claims)
+}
+
+// checkSecondFactor accepts either a TOTP code or one of the user's unused
+// recovery codes, consuming whichever was presented.
+func (h *Handler) checkSecondFactor(r *http.Request, user *t.User, payload *t.TwoFactorVerifyRequest) (bool, error) {
+	if payload.RecoveryCode != "" {
+		return h.store.UseRecoveryCode(r.Context(), user.ID, auth.HashRecoveryCode(payload.RecoveryCode))
+	}
+
+	step, ok := auth.ValidateTOTP(user.Security.TwoFactorSecret, payload.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return h.store.UseTwoFactorStep(r.Context(), user.ID, step)
+}
+
+// readMFAChallenge validates a challenge issued by issueMFAChallenge that has
+// not been answered yet.
+func readMFAChallenge(ctx context.Context, challenge string) (*auth.Claims, *ge.CustomError) {
+	token, err := auth.ValidateJWT(challenge, auth.MFAToken)
+	if errors.Is(err, auth.ErrTokenType) {
+		return nil, ge.WrongTokenType
+	}
+	if err != nil || !token.Valid {
+		return nil, ge.Unauthorized
+	}
+
+	claims := auth.ReadClaims(token)
+	revoked, err := auth.IsRevoked(ctx, claims)
+
+	if err != nil {
+		return nil, ge.Internal
+	}
+
+	if revoked {
+		return nil, ge.TokenRevoked
+	}
+
+	return claims, nil
+}
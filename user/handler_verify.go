@@ -0,0 +1,199 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	"github.com/findsam/food-server/config"
+	ge "github.com/findsam/food-server/error"
+	"github.com/findsam/food-server/mail"
+	t "github.com/findsam/food-server/types"
+	u "github.com/findsam/food-server/util"
+)
+
+// Values of EMAIL_VERIFICATION. VerifySignIn refuses to start a session for an
+// unverified address; VerifyRoutes only guards routes wrapped in
+// withVerifiedEmail.
+const (
+	VerifyNone   = "none"
+	VerifySignIn = "sign-in"
+	VerifyRoutes = "routes"
+)
+
+func (h *Handler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) error {
+	payload := new(t.VerifyEmailRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	token, err := auth.ValidateJWT(payload.Token, auth.VerifyToken)
+	if errors.Is(err, auth.ErrTokenType) {
+		return u.ERROR(w, ge.WrongTokenType)
+	}
+	if err != nil || !token.Valid {
+		return u.ERROR(w, ge.VerifyExpired)
+	}
+
+	claims := auth.ReadClaims(token)
+	revoked, err := auth.IsRevoked(r.Context(), claims)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	// tokens issued before the address moved into the email claim have to be
+	// sent again.
+	if revoked || claims.Email == "" {
+		return u.ERROR(w, ge.VerifyExpired)
+	}
+
+	user, err := h.store.GetUserByID(r.Context(), claims.Subject)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if user == nil {
+		return u.ERROR(w, ge.VerifyExpired)
+	}
+
+	verified, err := h.store.SetEmailVerified(r.Context(), user.ID, claims.Email)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !verified {
+		return u.ERROR(w, ge.VerifyExpired)
+	}
+
+	err = h.audit(r, t.AuditEvent{Type: EventEmailVerify, ActorID: user.ID.Hex(), Email: claims.Email})
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if err := auth.RevokeToken(r.Context(), claims); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Email address successfully verified",
+	})
+}
+
+func (h *Handler) handleResendVerification(w http.ResponseWriter, r *http.Request) error {
+	payload := new(t.ResetPasswordRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	start := time.Now()
+	user, err := h.store.GetUserByEmail(r.Context(), payload.Email)
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	// with accounts hidden, unknown and already verified addresses get the
+	// same answer as a sent email, and so does a resend that is too soon.
+	if config.Envs.HideAccounts {
+		if user != nil && !user.Meta.IsArchived && !user.Security.EmailVerified {
+			if cerr := h.sendVerification(r, user); cerr != nil && cerr != ge.TooManyRequests {
+				return u.ERROR(w, cerr)
+			}
+		}
+
+		padResponse(r, start)
+		return u.JSON(w, http.StatusOK, map[string]interface{}{
+			"message": fmt.Sprintf("Verification email sent to %s", payload.Email),
+		})
+	}
+
+	if user == nil || user.Meta.IsArchived {
+		return u.ERROR(w, ge.UserNotFound)
+	}
+
+	if user.Security.EmailVerified {
+		return u.ERROR(w, ge.EmailVerified)
+	}
+
+	if cerr := h.sendVerification(r, user); cerr != nil {
+		return u.ERROR(w, cerr)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("Verification email sent to %s", user.Email),
+	})
+}
+
+// sendVerification emails a verification link to the user's current address,
+// at most once every EMAIL_VERIFICATION_RESEND.
+func (h *Handler) sendVerification(r *http.Request, user *t.User) *ge.CustomError {
+	since := time.Now().Add(-config.Envs.VerifyResend).UTC()
+	ok, err := h.store.MarkVerificationSent(r.Context(), user.ID, since)
+
+	if err != nil {
+		return ge.Internal
+	}
+
+	if !ok {
+		return ge.TooManyRequests
+	}
+
+	claims, err := auth.NewClaims(auth.VerifyToken, user.ID.Hex(), time.Now().Add(auth.VerifyTokenTTL).UTC().Unix())
+	if err != nil {
+		return ge.Internal
+	}
+	claims.Email = user.Email
+
+	token, err := auth.SignClaims(claims)
+	if err != nil {
+		return ge.Internal
+	}
+
+	err = h.sendEmail(r, user, "verify", mail.Data{
+		"Link":  mail.Link("/verify-email", url.Values{"token": {token}}),
+		"Hours": int(auth.VerifyTokenTTL.Hours()),
+	})
+
+	if err != nil {
+		if err := h.store.ClearVerificationSent(r.Context(), user.ID); err != nil {
+			log.Printf("resetting the verification cooldown of user %s failed: %v", user.ID.Hex(), err)
+		}
+		return ge.Internal
+	}
+
+	return nil
+}
+
+// withVerifiedEmail refuses requests from users whose address is unverified
+// when EMAIL_VERIFICATION is "routes". It must run after auth.WithJWT.
+func (h *Handler) withVerifiedEmail(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.Envs.VerifyEmail != VerifyRoutes {
+			handlerFunc(w, r)
+			return
+		}
+
+		user, err := h.store.GetUserByID(r.Context(), r.Context().Value("uid").(string))
+
+		if err != nil || user == nil {
+			u.ERROR(w, ge.Internal)
+			return
+		}
+
+		if !user.Security.EmailVerified {
+			u.ERROR(w, ge.EmailNotVerified)
+			return
+		}
+
+		handlerFunc(w, r)
+	}
+}
@@ -2,27 +2,57 @@ package user
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/findsam/food-server/auth"
+	"github.com/findsam/food-server/config"
+	"github.com/findsam/food-server/db"
+	"github.com/findsam/food-server/outbox"
 	t "github.com/findsam/food-server/types"
 	u "github.com/findsam/food-server/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
-	DbName   = "base"
+	DbName   = db.DbName
 	CollName = "users"
 )
 
 type Store struct {
-	db *mongo.Client
+	db     *mongo.Client
+	outbox *outbox.MongoStore
 }
 
 func NewStore(db *mongo.Client) *Store {
-	return &Store{db: db}
+	return &Store{db: db, outbox: outbox.NewMongoStore(db)}
+}
+
+// EnsureIndexes creates the indexes, including TTL indexes that expire
+// short-lived records, needed by every collection the store touches.
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	if err := s.ensureUserIndexes(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureRefreshIndexes(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureSessionIndexes(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureResetIndexes(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureLoginAttemptIndexes(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureAuditIndexes(ctx); err != nil {
+		return err
+	}
+	return s.ensurePasskeyIndexes(ctx)
 }
 
 func (s *Store) Create(ctx context.Context, b t.RegisterRequest) error {
@@ -32,9 +62,15 @@ func (s *Store) Create(ctx context.Context, b t.RegisterRequest) error {
 		return err
 	}
 
+	user.ID = primitive.NewObjectID()
+
 	col := s.db.Database(DbName).Collection(CollName)
-	_, err = col.InsertOne(ctx, user)
-	return err
+	return s.withEvent(ctx, func(sc mongo.SessionContext) (*outbox.Event, error) {
+		if _, err := col.InsertOne(sc, user); err != nil {
+			return nil, err
+		}
+		return accountEvent(outbox.EventUserCreated, user, "")
+	})
 }
 
 func (s *Store) GetUserByEmail(ctx context.Context, email string) (*t.User, error) {
@@ -81,39 +117,89 @@ func (s *Store) UpdatePassword(ctx context.Context, uid primitive.ObjectID, p st
 	if err != nil {
 		return err
 	}
-	_, err = col.UpdateOne(context.TODO(), bson.M{"_id": uid}, bson.M{"$set": bson.M{"password": hashedPassword, "meta.lastUpdate": time.Now().UTC()}})
+	// the replaced hash joins the password history, which is trimmed to the
+	// configured length in the same update.
+	history := bson.M{"$slice": bson.A{
+		bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$passwordHistory", bson.A{}}}, bson.A{"$password"}}},
+		-config.Envs.PasswordPolicy.History,
+	}}
+	if config.Envs.PasswordPolicy.History <= 0 {
+		history = bson.M{"$literal": bson.A{}}
+	}
 
-	return err
+	_, err = col.UpdateOne(context.TODO(), bson.M{"_id": uid}, bson.A{bson.M{"$set": bson.M{
+		"password":        hashedPassword,
+		"passwordHistory": history,
+		"meta.lastUpdate": time.Now().UTC(),
+	}}})
+
+	if err != nil {
+		return err
+	}
+
+	// outstanding reset links must not outlive the password they were for.
+	return s.deletePasswordResets(ctx, uid.Hex())
 }
 
-func (s *Store) UpdateUser(ctx context.Context, b t.UpdateUserRequest) error {
+// RehashPassword swaps the stored hash for one of the same password made with
+// the current hasher, unless the password was changed in the meantime.
+func (s *Store) RehashPassword(ctx context.Context, uid primitive.ObjectID, oldHash string, newHash string) error {
 	col := s.db.Database(DbName).Collection(CollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": uid, "password": oldHash}, bson.M{"$set": bson.M{"password": newHash}})
+	return err
+}
 
+func (s *Store) UpdateUser(ctx context.Context, b t.UpdateUserRequest) error {
 	oid, err := primitive.ObjectIDFromHex(b.ID)
 
 	if err != nil {
 		return err
 	}
 
-	_, err = col.UpdateOne(context.TODO(), bson.M{"_id": oid}, bson.M{
-		"$set": bson.M{
-			"firstName":       u.CapitalizeFirstLetter(b.FirstName),
-			"lastName":        u.CapitalizeFirstLetter(b.LastName),
-			"email":           b.Email,
-			"meta.lastUpdate": time.Now().UTC(),
-		},
+	set := bson.M{
+		"firstName":       u.CapitalizeFirstLetter(b.FirstName),
+		"lastName":        u.CapitalizeFirstLetter(b.LastName),
+		"meta.lastUpdate": time.Now().UTC(),
+	}
+	// an update that leaves out the language keeps the stored one.
+	if b.Language != "" {
+		set["language"] = b.Language
+	}
+
+	return s.withEvent(ctx, func(sc mongo.SessionContext) (*outbox.Event, error) {
+		return s.updateWithEvent(sc, outbox.EventUserUpdated, oid, bson.M{"$set": set})
 	})
-	return err
 }
 
 func (s *Store) ArchiveUser(ctx context.Context, uid string) error {
-	col := s.db.Database(DbName).Collection(CollName)
 	oid, err := primitive.ObjectIDFromHex(uid)
 
 	if err != nil {
 		return err
 	}
-	_, err = col.UpdateOne(context.TODO(), bson.M{"_id": oid}, bson.M{"$set": bson.M{"meta.isArchived": true, "meta.lastUpdate": time.Now().UTC()}})
+	return s.withEvent(ctx, func(sc mongo.SessionContext) (*outbox.Event, error) {
+		return s.updateWithEvent(sc, outbox.EventUserArchived, oid, bson.M{
+			"$set": bson.M{"meta.isArchived": true, "meta.lastUpdate": time.Now().UTC()},
+		})
+	})
+}
 
-	return err
+// updateWithEvent applies update to the user and describes the result as an
+// event of type typ. There is no event when the user does not exist.
+func (s *Store) updateWithEvent(sc mongo.SessionContext, typ string, uid primitive.ObjectID, update bson.M) (*outbox.Event, error) {
+	col := s.db.Database(DbName).Collection(CollName)
+
+	user := new(t.User)
+	err := col.FindOneAndUpdate(sc, bson.M{"_id": uid}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(user)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return accountEvent(typ, user, "")
 }
@@ -0,0 +1,88 @@
+package user
+
+import (
+	"context"
+
+	t "github.com/findsam/food-server/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditCollName holds the audit log. The store only ever inserts into it.
+const AuditCollName = "auditEvents"
+
+// legacySecurityCollName held the refresh token reuse events recorded before
+// the audit log existed.
+const legacySecurityCollName = "securityEvents"
+
+func (s *Store) RecordAuditEvent(ctx context.Context, e t.AuditEvent) error {
+	col := s.db.Database(DbName).Collection(AuditCollName)
+	_, err := col.InsertOne(ctx, e)
+	return err
+}
+
+// GetAuditEvents returns the latest limit events that happened to the account
+// uid, newest first.
+func (s *Store) GetAuditEvents(ctx context.Context, uid string, limit int) ([]t.AuditEvent, error) {
+	col := s.db.Database(DbName).Collection(AuditCollName)
+
+	cursor, err := col.Find(ctx, bson.M{"targetId": uid}, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	events := []t.AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (s *Store) ensureAuditIndexes(ctx context.Context) error {
+	col := s.db.Database(DbName).Collection(AuditCollName)
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.migrateSecurityEvents(ctx)
+}
+
+// migrateSecurityEvents moves the events of the old securityEvents collection
+// into the audit log and drops it. It does nothing once that has happened.
+func (s *Store) migrateSecurityEvents(ctx context.Context) error {
+	database := s.db.Database(DbName)
+
+	names, err := database.ListCollectionNames(ctx, bson.M{"name": legacySecurityCollName})
+	if err != nil || len(names) == 0 {
+		return err
+	}
+
+	legacy := database.Collection(legacySecurityCollName)
+	cursor, err := legacy.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{
+			"type":      1,
+			"outcome":   bson.M{"$literal": OutcomeFailure},
+			"actorId":   "$userId",
+			"targetId":  "$userId",
+			"sessionId": "$familyId",
+			"ip":        1,
+			"userAgent": 1,
+			"createdAt": 1,
+		}}},
+		{{Key: "$merge", Value: bson.M{"into": AuditCollName, "whenMatched": "keepExisting"}}},
+	})
+	if err != nil {
+		return err
+	}
+	cursor.Close(ctx)
+
+	return legacy.Drop(ctx)
+}
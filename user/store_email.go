@@ -0,0 +1,146 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/findsam/food-server/outbox"
+	t "github.com/findsam/food-server/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrEmailExists is returned when an address is already held by another user.
+var ErrEmailExists = errors.New("email address is already in use")
+
+func (s *Store) ensureUserIndexes(ctx context.Context) error {
+	if err := s.checkDuplicateEmails(ctx); err != nil {
+		return err
+	}
+
+	col := s.db.Database(DbName).Collection(CollName)
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// checkDuplicateEmails refuses to go on while several users share an address,
+// which the unique email index cannot be built over. Users signed up before
+// addresses were unique have to be merged or archived and renamed by hand, so
+// the error lists their ids.
+func (s *Store) checkDuplicateEmails(ctx context.Context) error {
+	col := s.db.Database(DbName).Collection(CollName)
+	cursor, err := col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$email",
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	duplicates := []struct {
+		Email string               `bson:"_id"`
+		IDs   []primitive.ObjectID `bson:"ids"`
+	}{}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return err
+	}
+
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	conflicts := []string{}
+	for _, d := range duplicates {
+		ids := []string{}
+		for _, id := range d.IDs {
+			ids = append(ids, id.Hex())
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s (%s)", d.Email, strings.Join(ids, ", ")))
+	}
+
+	return fmt.Errorf("cannot enforce unique emails, these addresses belong to several users: %s", strings.Join(conflicts, "; "))
+}
+
+func (s *Store) SetPendingEmail(ctx context.Context, uid primitive.ObjectID, email string) error {
+	col := s.db.Database(DbName).Collection(CollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": uid}, bson.M{"$set": bson.M{
+		"security.pendingEmail": email,
+		"meta.lastUpdate":       time.Now().UTC(),
+	}})
+	return err
+}
+
+// ConfirmEmailChange swaps in email, which is verified by the confirmation
+// link having been opened, as long as it is still the pending address. It
+// reports false otherwise and returns ErrEmailExists if it was taken since.
+func (s *Store) ConfirmEmailChange(ctx context.Context, uid primitive.ObjectID, email string) (bool, error) {
+	changed := false
+	err := s.withEvent(ctx, func(sc mongo.SessionContext) (*outbox.Event, error) {
+		e, err := s.setEmail(sc, bson.M{"_id": uid, "security.pendingEmail": email}, email)
+		changed = e != nil
+		return e, err
+	})
+
+	if mongo.IsDuplicateKeyError(err) {
+		return false, ErrEmailExists
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// RevertEmailChange restores email and drops any pending change.
+func (s *Store) RevertEmailChange(ctx context.Context, uid primitive.ObjectID, email string) error {
+	err := s.withEvent(ctx, func(sc mongo.SessionContext) (*outbox.Event, error) {
+		return s.setEmail(sc, bson.M{"_id": uid}, email)
+	})
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailExists
+	}
+
+	return err
+}
+
+// setEmail makes email the verified address of the user matching filter and
+// describes the change as an event. There is no event when no user matches.
+func (s *Store) setEmail(sc mongo.SessionContext, filter bson.M, email string) (*outbox.Event, error) {
+	col := s.db.Database(DbName).Collection(CollName)
+
+	before := new(t.User)
+	err := col.FindOneAndUpdate(sc, filter, bson.M{
+		"$set": bson.M{
+			"email":                  email,
+			"security.emailVerified": true,
+			"meta.lastUpdate":        time.Now().UTC(),
+		},
+		"$unset": bson.M{"security.pendingEmail": ""},
+	}).Decode(before)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	after := *before
+	after.Email = email
+	return accountEvent(outbox.EventUserEmailChanged, &after, before.Email)
+}
@@ -0,0 +1,76 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	t "github.com/findsam/food-server/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const LoginAttemptCollName = "loginAttempts"
+
+func (s *Store) ensureLoginAttemptIndexes(ctx context.Context) error {
+	col := s.db.Database(DbName).Collection(LoginAttemptCollName)
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (s *Store) GetLoginAttempts(ctx context.Context, keys ...string) ([]t.LoginAttempt, error) {
+	col := s.db.Database(DbName).Collection(LoginAttemptCollName)
+
+	cursor, err := col.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := []t.LoginAttempt{}
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// RecordLoginFailure counts a failed sign-in against key and returns the
+// number of failures since key was last cleared. The count is forgotten once
+// window passes without another failure.
+func (s *Store) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	col := s.db.Database(DbName).Collection(LoginAttemptCollName)
+	now := time.Now().UTC()
+
+	attempt := new(t.LoginAttempt)
+	err := col.FindOneAndUpdate(ctx, bson.M{"_id": key}, bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"lastFailure": now},
+		"$max": bson.M{"expiresAt": now.Add(window)},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(attempt)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return attempt.Failures, nil
+}
+
+// LockLogin refuses sign-in for key until until. The record is kept at least
+// that long so the lock cannot expire early.
+func (s *Store) LockLogin(ctx context.Context, key string, until time.Time) error {
+	col := s.db.Database(DbName).Collection(LoginAttemptCollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": key}, bson.M{
+		"$set": bson.M{"lockedUntil": until},
+		"$max": bson.M{"expiresAt": until},
+	})
+	return err
+}
+
+func (s *Store) ClearLoginAttempts(ctx context.Context, key string) error {
+	col := s.db.Database(DbName).Collection(LoginAttemptCollName)
+	_, err := col.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
@@ -0,0 +1,44 @@
+package user
+
+import (
+	"context"
+
+	"github.com/findsam/food-server/outbox"
+	t "github.com/findsam/food-server/types"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// withEvent runs mutate in a transaction and appends the event it returns, if
+// any, to the outbox in the same transaction, so the event is published if and
+// only if the change is committed. Transactions need Mongo to run as a replica
+// set.
+func (s *Store) withEvent(ctx context.Context, mutate func(sc mongo.SessionContext) (*outbox.Event, error)) error {
+	session, err := s.db.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		e, err := mutate(sc)
+		if err != nil || e == nil {
+			return nil, err
+		}
+		return nil, s.outbox.Append(sc, *e)
+	})
+	return err
+}
+
+// accountEvent describes a change to user. previousEmail is only set when the
+// change replaced the address.
+func accountEvent(typ string, user *t.User, previousEmail string) (*outbox.Event, error) {
+	e, err := outbox.NewEvent(typ, t.AccountEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		PreviousEmail: previousEmail,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
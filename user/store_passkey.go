@@ -0,0 +1,103 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	t "github.com/findsam/food-server/types"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	PasskeyCollName  = "passkeys"
+	CeremonyCollName = "passkeyCeremonies"
+)
+
+func (s *Store) ensurePasskeyIndexes(ctx context.Context) error {
+	col := s.db.Database(DbName).Collection(PasskeyCollName)
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "credential.id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	col = s.db.Database(DbName).Collection(CeremonyCollName)
+	_, err = col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (s *Store) CreatePasskey(ctx context.Context, pk t.Passkey) error {
+	col := s.db.Database(DbName).Collection(PasskeyCollName)
+	_, err := col.InsertOne(ctx, pk)
+	return err
+}
+
+func (s *Store) GetPasskeys(ctx context.Context, uid string) ([]t.Passkey, error) {
+	col := s.db.Database(DbName).Collection(PasskeyCollName)
+
+	cursor, err := col.Find(ctx, bson.M{"userId": uid})
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys := []t.Passkey{}
+	err = cursor.All(ctx, &passkeys)
+	return passkeys, err
+}
+
+// UpdatePasskeyCredential stores the sign counter and flags reported by the
+// latest assertion.
+func (s *Store) UpdatePasskeyCredential(ctx context.Context, id primitive.ObjectID, cred webauthn.Credential) error {
+	col := s.db.Database(DbName).Collection(PasskeyCollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"credential": cred,
+		"lastUsed":   time.Now().UTC(),
+	}})
+	return err
+}
+
+func (s *Store) DeletePasskey(ctx context.Context, uid string, id string) (bool, error) {
+	col := s.db.Database(DbName).Collection(PasskeyCollName)
+	oid, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return false, nil
+	}
+
+	res, err := col.DeleteOne(ctx, bson.M{"_id": oid, "userId": uid})
+	if err != nil {
+		return false, err
+	}
+
+	return res.DeletedCount == 1, nil
+}
+
+func (s *Store) CreatePasskeyCeremony(ctx context.Context, c t.PasskeyCeremony) error {
+	col := s.db.Database(DbName).Collection(CeremonyCollName)
+	_, err := col.InsertOne(ctx, c)
+	return err
+}
+
+// TakePasskeyCeremony removes and returns the ceremony so its challenge can
+// only ever be answered once.
+func (s *Store) TakePasskeyCeremony(ctx context.Context, id string) (*t.PasskeyCeremony, error) {
+	col := s.db.Database(DbName).Collection(CeremonyCollName)
+
+	c := new(t.PasskeyCeremony)
+	err := col.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(c)
+
+	if c.ID == "" || c.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+
+	return c, err
+}
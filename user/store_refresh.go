@@ -0,0 +1,78 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	t "github.com/findsam/food-server/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const RefreshCollName = "refreshTokens"
+
+func (s *Store) CreateRefreshToken(ctx context.Context, rt t.RefreshToken) error {
+	col := s.db.Database(DbName).Collection(RefreshCollName)
+	_, err := col.InsertOne(ctx, rt)
+	return err
+}
+
+func (s *Store) GetRefreshToken(ctx context.Context, jti string) (*t.RefreshToken, error) {
+	col := s.db.Database(DbName).Collection(RefreshCollName)
+
+	rt := new(t.RefreshToken)
+	err := col.FindOne(ctx, bson.M{"_id": jti}).Decode(rt)
+
+	if rt.ID == "" {
+		return nil, nil
+	}
+
+	return rt, err
+}
+
+func (s *Store) ensureRefreshIndexes(ctx context.Context) error {
+	col := s.db.Database(DbName).Collection(RefreshCollName)
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "familyId", Value: 1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// ConsumeRefreshToken atomically marks the token as rotated. It reports false
+// when the token was already rotated or revoked, which means it is being reused.
+func (s *Store) ConsumeRefreshToken(ctx context.Context, jti string) (bool, error) {
+	col := s.db.Database(DbName).Collection(RefreshCollName)
+
+	res, err := col.UpdateOne(ctx, bson.M{
+		"_id":       jti,
+		"rotatedAt": bson.M{"$exists": false},
+		"revokedAt": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"rotatedAt": time.Now().UTC()}})
+
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+func (s *Store) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	col := s.db.Database(DbName).Collection(RefreshCollName)
+	_, err := col.UpdateMany(ctx, bson.M{
+		"familyId":  familyID,
+		"revokedAt": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}})
+	return err
+}
+
+func (s *Store) RevokeUserRefreshTokens(ctx context.Context, uid string) error {
+	col := s.db.Database(DbName).Collection(RefreshCollName)
+	_, err := col.UpdateMany(ctx, bson.M{
+		"userId":    uid,
+		"revokedAt": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}})
+	return err
+}
@@ -0,0 +1,79 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	t "github.com/findsam/food-server/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const ResetCollName = "passwordResets"
+
+func (s *Store) ensureResetIndexes(ctx context.Context) error {
+	col := s.db.Database(DbName).Collection(ResetCollName)
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// CreatePasswordReset stores a new reset, invalidating any earlier one for the
+// same user so only the latest link works.
+func (s *Store) CreatePasswordReset(ctx context.Context, reset t.PasswordReset) error {
+	if err := s.deletePasswordResets(ctx, reset.UserID); err != nil {
+		return err
+	}
+
+	col := s.db.Database(DbName).Collection(ResetCollName)
+	_, err := col.InsertOne(ctx, reset)
+	return err
+}
+
+// GetPasswordReset returns the reset with the given hash while it can still be
+// used, or nil.
+func (s *Store) GetPasswordReset(ctx context.Context, hash string) (*t.PasswordReset, error) {
+	col := s.db.Database(DbName).Collection(ResetCollName)
+
+	reset := new(t.PasswordReset)
+	err := col.FindOne(ctx, bson.M{
+		"_id":       hash,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}).Decode(reset)
+
+	if reset.ID == "" {
+		return nil, nil
+	}
+
+	return reset, err
+}
+
+// ConsumePasswordReset atomically marks the reset with the given hash used and
+// returns it, or nil when it is unknown, expired or was already used.
+func (s *Store) ConsumePasswordReset(ctx context.Context, hash string) (*t.PasswordReset, error) {
+	col := s.db.Database(DbName).Collection(ResetCollName)
+	now := time.Now().UTC()
+
+	reset := new(t.PasswordReset)
+	err := col.FindOneAndUpdate(ctx, bson.M{
+		"_id":       hash,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": now},
+	}, bson.M{"$set": bson.M{"usedAt": now}}).Decode(reset)
+
+	if reset.ID == "" {
+		return nil, nil
+	}
+
+	return reset, err
+}
+
+func (s *Store) deletePasswordResets(ctx context.Context, uid string) error {
+	col := s.db.Database(DbName).Collection(ResetCollName)
+	_, err := col.DeleteMany(ctx, bson.M{"userId": uid})
+	return err
+}
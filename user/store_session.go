@@ -0,0 +1,87 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	t "github.com/findsam/food-server/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const SessionCollName = "sessions"
+
+func (s *Store) ensureSessionIndexes(ctx context.Context) error {
+	col := s.db.Database(DbName).Collection(SessionCollName)
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "lastSeen", Value: -1}}},
+		{Keys: bson.D{{Key: "familyId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (s *Store) CreateSession(ctx context.Context, session t.Session) error {
+	col := s.db.Database(DbName).Collection(SessionCollName)
+	_, err := col.InsertOne(ctx, session)
+	return err
+}
+
+func (s *Store) GetSessions(ctx context.Context, uid string) ([]t.Session, error) {
+	col := s.db.Database(DbName).Collection(SessionCollName)
+
+	cursor, err := col.Find(ctx, bson.M{"userId": uid}, options.Find().SetSort(bson.M{"lastSeen": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []t.Session{}
+	err = cursor.All(ctx, &sessions)
+	return sessions, err
+}
+
+func (s *Store) GetSession(ctx context.Context, uid string, id string) (*t.Session, error) {
+	col := s.db.Database(DbName).Collection(SessionCollName)
+	oid, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return nil, nil
+	}
+
+	session := new(t.Session)
+	err = col.FindOne(ctx, bson.M{"_id": oid, "userId": uid}).Decode(session)
+
+	if primitive.ObjectID.IsZero(session.ID) {
+		return nil, nil
+	}
+
+	return session, err
+}
+
+// TouchSession records activity on the session bound to a refresh family and
+// extends its expiry to match the newly issued refresh token.
+func (s *Store) TouchSession(ctx context.Context, familyID string, ip string, userAgent string) error {
+	col := s.db.Database(DbName).Collection(SessionCollName)
+	_, err := col.UpdateOne(ctx, bson.M{"familyId": familyID}, bson.M{"$set": bson.M{
+		"ip":        ip,
+		"userAgent": userAgent,
+		"lastSeen":  time.Now().UTC(),
+		"expiresAt": time.Now().Add(auth.RefreshTokenTTL).UTC(),
+	}})
+	return err
+}
+
+func (s *Store) DeleteSession(ctx context.Context, familyID string) error {
+	col := s.db.Database(DbName).Collection(SessionCollName)
+	_, err := col.DeleteOne(ctx, bson.M{"familyId": familyID})
+	return err
+}
+
+func (s *Store) DeleteUserSessions(ctx context.Context, uid string) error {
+	col := s.db.Database(DbName).Collection(SessionCollName)
+	_, err := col.DeleteMany(ctx, bson.M{"userId": uid})
+	return err
+}
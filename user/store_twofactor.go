@@ -0,0 +1,78 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *Store) SetPendingTwoFactor(ctx context.Context, uid primitive.ObjectID, secret string) error {
+	col := s.db.Database(DbName).Collection(CollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": uid}, bson.M{"$set": bson.M{
+		"security.twoFactorPending": secret,
+		"meta.lastUpdate":           time.Now().UTC(),
+	}})
+	return err
+}
+
+// EnableTwoFactor promotes the pending secret once its first code has been
+// confirmed, recording that code's time step so it cannot be replayed, along
+// with the hashes of the initial recovery codes.
+func (s *Store) EnableTwoFactor(ctx context.Context, uid primitive.ObjectID, secret string, step int64, codes []string) error {
+	col := s.db.Database(DbName).Collection(CollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": uid}, bson.M{
+		"$set": bson.M{
+			"security.hasTwoFactor":    true,
+			"security.twoFactorSecret": secret,
+			"security.twoFactorStep":   step,
+			"security.recoveryCodes":   codes,
+			"meta.lastUpdate":          time.Now().UTC(),
+		},
+		"$unset": bson.M{"security.twoFactorPending": ""},
+	})
+	return err
+}
+
+// UseTwoFactorStep atomically records step as used. It reports false when a
+// code from the same or a later step was already accepted.
+func (s *Store) UseTwoFactorStep(ctx context.Context, uid primitive.ObjectID, step int64) (bool, error) {
+	col := s.db.Database(DbName).Collection(CollName)
+	res, err := col.UpdateOne(ctx, bson.M{
+		"_id":                    uid,
+		"security.twoFactorStep": bson.M{"$lt": step},
+	}, bson.M{"$set": bson.M{"security.twoFactorStep": step}})
+
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+// SetRecoveryCodes replaces every recovery code hash, invalidating the old set.
+func (s *Store) SetRecoveryCodes(ctx context.Context, uid primitive.ObjectID, codes []string) error {
+	col := s.db.Database(DbName).Collection(CollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": uid}, bson.M{"$set": bson.M{
+		"security.recoveryCodes": codes,
+		"meta.lastUpdate":        time.Now().UTC(),
+	}})
+	return err
+}
+
+// UseRecoveryCode atomically removes the recovery code hash. It reports false
+// when the code is unknown or was already used.
+func (s *Store) UseRecoveryCode(ctx context.Context, uid primitive.ObjectID, hash string) (bool, error) {
+	col := s.db.Database(DbName).Collection(CollName)
+	res, err := col.UpdateOne(ctx, bson.M{
+		"_id":                    uid,
+		"security.recoveryCodes": hash,
+	}, bson.M{"$pull": bson.M{"security.recoveryCodes": hash}})
+
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
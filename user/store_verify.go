@@ -0,0 +1,72 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/findsam/food-server/outbox"
+	t "github.com/findsam/food-server/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SetEmailVerified marks the address verified, but only while it is still the
+// one the verification token was issued for. It reports false otherwise. An
+// address that was verified already stays so without another event.
+func (s *Store) SetEmailVerified(ctx context.Context, uid primitive.ObjectID, email string) (bool, error) {
+	col := s.db.Database(DbName).Collection(CollName)
+	verified := false
+	err := s.withEvent(ctx, func(sc mongo.SessionContext) (*outbox.Event, error) {
+		res, err := col.UpdateOne(sc, bson.M{
+			"_id":                    uid,
+			"email":                  email,
+			"security.emailVerified": false,
+		}, bson.M{"$set": bson.M{
+			"security.emailVerified": true,
+			"meta.lastUpdate":        time.Now().UTC(),
+		}})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if res.MatchedCount == 0 {
+			n, err := col.CountDocuments(sc, bson.M{"_id": uid, "email": email})
+			verified = n > 0
+			return nil, err
+		}
+
+		verified = true
+		return accountEvent(outbox.EventUserEmailVerified, &t.User{ID: uid, Email: email}, "")
+	})
+
+	return verified, err
+}
+
+// MarkVerificationSent atomically records that a verification email is being
+// sent. It reports false when the previous one went out after since.
+func (s *Store) MarkVerificationSent(ctx context.Context, uid primitive.ObjectID, since time.Time) (bool, error) {
+	col := s.db.Database(DbName).Collection(CollName)
+	res, err := col.UpdateOne(ctx, bson.M{
+		"_id": uid,
+		"$or": bson.A{
+			bson.M{"security.verificationSentAt": bson.M{"$exists": false}},
+			bson.M{"security.verificationSentAt": bson.M{"$lte": since}},
+		},
+	}, bson.M{"$set": bson.M{"security.verificationSentAt": time.Now().UTC()}})
+
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+// ClearVerificationSent forgets the last verification email, for when sending
+// it failed, so it can be resent right away.
+func (s *Store) ClearVerificationSent(ctx context.Context, uid primitive.ObjectID) error {
+	col := s.db.Database(DbName).Collection(CollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": uid}, bson.M{"$unset": bson.M{"security.verificationSentAt": ""}})
+	return err
+}
@@ -19,6 +19,7 @@ func NewAccount(p t.RegisterRequest) (*t.User, error) {
 		FirstName: u.CapitalizeFirstLetter(p.FirstName),
 		LastName:  u.CapitalizeFirstLetter(p.LastName),
 		Password:  string(hashedPassword),
+		Language:  p.Language,
 		Meta: t.UserMeta{
 			CreatedAt:  time.Now().UTC(),
 			LastUpdate: time.Now().UTC(),
@@ -26,7 +27,6 @@ func NewAccount(p t.RegisterRequest) (*t.User, error) {
 		Security: t.UserSecurity{
 			EmailVerified: false,
 			HasTwoFactor:  false,
-			TwoFactorCode: 0,
 		},
 	}, nil
 }
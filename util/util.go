@@ -2,6 +2,7 @@ package util
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 	"strings"
 
@@ -16,6 +17,14 @@ func GetTokenFromRequest(r *http.Request) string {
 	return ""
 }
 
+func GetIPFromRequest(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func MakeHTTPHandlerFunc(fn func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := fn(w, r); err != nil {
@@ -0,0 +1,187 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/findsam/food-server/outbox"
+)
+
+// RetryPolicy spaces out attempts on a failing delivery. The wait before the
+// nth retry is BaseDelay doubled n-1 times, capped at MaxDelay, and after
+// MaxAttempts failed attempts the delivery is dead.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Backoff returns the wait after the given number of failed attempts.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// maxErrorLength bounds the receiver response kept on a failed attempt.
+const maxErrorLength = 512
+
+// Dispatcher queues events for subscribers and delivers them.
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	policy RetryPolicy
+}
+
+func NewDispatcher(store Store, client *http.Client, policy RetryPolicy) *Dispatcher {
+	return &Dispatcher{store: store, client: client, policy: policy}
+}
+
+// Publish queues a delivery of e for every subscription that asked for it,
+// making the dispatcher an outbox.Sink. Delivery itself happens in the
+// background, see Run. Publishing the same event again queues nothing new.
+func (d *Dispatcher) Publish(ctx context.Context, e outbox.Event) error {
+	subs, err := d.store.GetSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	deliveries := []Delivery{}
+	for _, sub := range subs {
+		if !sub.Wants(e.Type) {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			ID:             e.ID + "." + sub.ID,
+			SubscriptionID: sub.ID,
+			EventID:        e.ID,
+			Event:          e.Type,
+			Payload:        payload,
+			Status:         StatusPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return d.store.CreateDeliveries(ctx, deliveries)
+}
+
+// Run delivers due deliveries every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.ProcessDue(ctx, time.Now().UTC())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue attempts every delivery due at now and returns how many it
+// attempted.
+func (d *Dispatcher) ProcessDue(ctx context.Context, now time.Time) int {
+	// the lease outlasts the request timeout, so a delivery is only picked up
+	// again if the worker attempting it died.
+	lease := 2*d.client.Timeout + time.Minute
+
+	n := 0
+	for ctx.Err() == nil {
+		delivery, err := d.store.ClaimDelivery(ctx, now, lease)
+		if err != nil || delivery == nil {
+			return n
+		}
+
+		d.attempt(ctx, delivery, now)
+		n++
+	}
+	return n
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery, now time.Time) {
+	status, err := d.send(ctx, delivery)
+
+	delivery.Attempts++
+	delivery.LastStatusCode = status
+
+	switch {
+	case err == nil:
+		delivery.Status = StatusDelivered
+		delivery.DeliveredAt = now
+		delivery.LastError = ""
+	case delivery.Attempts >= d.policy.MaxAttempts:
+		delivery.Status = StatusDead
+		delivery.LastError = truncate(err.Error())
+	default:
+		delivery.NextAttemptAt = now.Add(d.policy.Backoff(delivery.Attempts))
+		delivery.LastError = truncate(err.Error())
+	}
+
+	// should this fail, the delivery is attempted again once the lease runs
+	// out. Receivers must tolerate duplicates in any case.
+	d.store.SaveAttempt(ctx, *delivery)
+}
+
+// send posts the delivery once, treating any 2xx answer as success.
+func (d *Dispatcher) send(ctx context.Context, delivery *Delivery) (int, error) {
+	sub, err := d.store.GetSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return 0, err
+	}
+
+	if sub == nil {
+		return 0, fmt.Errorf("subscription %s no longer exists", delivery.SubscriptionID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, delivery.ID)
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, time.Now(), delivery.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorLength))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("receiver answered %d: %s", res.StatusCode, body)
+	}
+	return res.StatusCode, nil
+}
+
+func truncate(s string) string {
+	if len(s) > maxErrorLength {
+		return s[:maxErrorLength]
+	}
+	return s
+}
@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/findsam/food-server/outbox"
+)
+
+// receiver records the requests it gets and answers with status.
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func newTestDispatcher(t *testing.T, rc *receiver, events ...string) (*Dispatcher, *MemoryStore) {
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+
+	store := NewMemoryStore()
+	store.CreateSubscription(context.Background(), Subscription{
+		ID:        "sub",
+		URL:       server.URL,
+		Events:    events,
+		Secret:    "secret",
+		CreatedAt: time.Now(),
+	})
+
+	d := NewDispatcher(store, &http.Client{Timeout: time.Second}, RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Minute,
+		MaxDelay:    time.Hour,
+	})
+	return d, store
+}
+
+func newTestEvent(t *testing.T, typ string, data interface{}) outbox.Event {
+	e, err := outbox.NewEvent(typ, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	cases := map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		50: 10 * time.Second,
+	}
+
+	for attempts, want := range cases {
+		if got := p.Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d): got %v want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	d, store := newTestDispatcher(t, rc, outbox.EventUserCreated)
+	ctx := context.Background()
+
+	created := newTestEvent(t, outbox.EventUserCreated, map[string]string{"id": "42"})
+	if err := d.Publish(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Publish(ctx, newTestEvent(t, outbox.EventUserArchived, map[string]string{"id": "42"})); err != nil {
+		t.Fatal(err)
+	}
+	// the relay publishes again when it stops before recording the event.
+	if err := d.Publish(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := d.ProcessDue(ctx, time.Now()); n != 1 {
+		t.Fatalf("attempted %d deliveries, want only the subscribed event", n)
+	}
+
+	req, body := rc.requests[0], rc.bodies[0]
+	if err := Verify("secret", req.Header.Get(SignatureHeader), body, time.Now(), time.Minute); err != nil {
+		t.Errorf("receiver could not verify the signature: %v", err)
+	}
+
+	if req.Header.Get(EventHeader) != outbox.EventUserCreated {
+		t.Errorf("event header: got %q", req.Header.Get(EventHeader))
+	}
+
+	payload := struct {
+		ID   string            `json:"id"`
+		Type string            `json:"type"`
+		Data map[string]string `json:"data"`
+	}{}
+	err := json.Unmarshal(body, &payload)
+	if err != nil || payload.ID != created.ID || payload.Type != outbox.EventUserCreated || payload.Data["id"] != "42" {
+		t.Errorf("unexpected payload %s", body)
+	}
+
+	deliveries, _ := store.GetDeliveries(ctx, DeliveryFilter{})
+	if len(deliveries) != 1 || deliveries[0].Status != StatusDelivered || deliveries[0].Attempts != 1 {
+		t.Errorf("delivery not recorded as delivered: %+v", deliveries)
+	}
+}
+
+func TestDispatcher_RetryAndDeadLetter(t *testing.T) {
+	rc := &receiver{status: http.StatusInternalServerError}
+	d, store := newTestDispatcher(t, rc, outbox.EventUserCreated)
+	ctx := context.Background()
+
+	d.Publish(ctx, newTestEvent(t, outbox.EventUserCreated, nil))
+	now := time.Now()
+
+	d.ProcessDue(ctx, now)
+	if n := d.ProcessDue(ctx, now.Add(30*time.Second)); n != 0 {
+		t.Fatal("a failed delivery should wait for its backoff")
+	}
+
+	// 1 minute after the first failure, then 2 minutes after the second.
+	d.ProcessDue(ctx, now.Add(time.Minute))
+	d.ProcessDue(ctx, now.Add(3*time.Minute))
+
+	deliveries, _ := store.GetDeliveries(ctx, DeliveryFilter{Status: StatusDead})
+	if len(deliveries) != 1 {
+		t.Fatalf("delivery should be dead after 3 attempts, got %d requests", len(rc.requests))
+	}
+
+	dead := deliveries[0]
+	if dead.Attempts != 3 || dead.LastStatusCode != http.StatusInternalServerError || dead.LastError == "" {
+		t.Errorf("dead delivery missing its last attempt: %+v", dead)
+	}
+
+	if n := d.ProcessDue(ctx, now.Add(24*time.Hour)); n != 0 {
+		t.Error("dead deliveries should not be attempted again")
+	}
+
+	rc.mu.Lock()
+	rc.status = http.StatusOK
+	rc.mu.Unlock()
+
+	if ok, _ := store.ReplayDelivery(ctx, dead.ID, now.Add(24*time.Hour)); !ok {
+		t.Fatal("replay should find the delivery")
+	}
+
+	d.ProcessDue(ctx, now.Add(24*time.Hour))
+
+	replayed, _ := store.GetDelivery(ctx, dead.ID)
+	if replayed.Status != StatusDelivered || len(rc.requests) != 4 {
+		t.Errorf("replayed delivery was not delivered: %+v", replayed)
+	}
+
+	if rc.requests[3].Header.Get(IDHeader) != rc.requests[0].Header.Get(IDHeader) {
+		t.Error("every attempt should carry the same delivery id")
+	}
+}
@@ -0,0 +1,191 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	ge "github.com/findsam/food-server/error"
+	"github.com/findsam/food-server/outbox"
+	u "github.com/findsam/food-server/util"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+type SubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// Handler serves the admin API for subscriptions and deliveries. Every route
+// requires the admin key; see auth.WithAdminKey.
+type Handler struct {
+	dispatcher *Dispatcher
+}
+
+func NewHandler(dispatcher *Dispatcher) *Handler {
+	return &Handler{dispatcher: dispatcher}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Route("/admin/webhooks", func(r chi.Router) {
+		r.Get("/", auth.WithAdminKey(u.MakeHTTPHandlerFunc(h.handleGetSubscriptions)))
+		r.Post("/", auth.WithAdminKey(u.MakeHTTPHandlerFunc(h.handleCreateSubscription)))
+		r.Delete("/{id}", auth.WithAdminKey(u.MakeHTTPHandlerFunc(h.handleDeleteSubscription)))
+		r.Get("/deliveries", auth.WithAdminKey(u.MakeHTTPHandlerFunc(h.handleGetDeliveries)))
+		r.Get("/deliveries/{id}", auth.WithAdminKey(u.MakeHTTPHandlerFunc(h.handleGetDelivery)))
+		r.Post("/deliveries/{id}/replay", auth.WithAdminKey(u.MakeHTTPHandlerFunc(h.handleReplayDelivery)))
+	})
+}
+
+func (h *Handler) handleGetSubscriptions(w http.ResponseWriter, r *http.Request) error {
+	subs, err := h.dispatcher.store.GetSubscriptions(r.Context())
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"results": subs,
+	})
+}
+
+// handleCreateSubscription answers with the signing secret, which is never
+// shown again.
+func (h *Handler) handleCreateSubscription(w http.ResponseWriter, r *http.Request) error {
+	payload := new(SubscriptionRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return u.ERROR(w, ge.BadRequest)
+	}
+
+	if !validURL(payload.URL) || !validEvents(payload.Events) {
+		return u.ERROR(w, ge.BadRequest)
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	sub := Subscription{
+		ID:        primitive.NewObjectID().Hex(),
+		URL:       payload.URL,
+		Events:    payload.Events,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := h.dispatcher.store.CreateSubscription(r.Context(), sub); err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"results": []Subscription{sub},
+		"secret":  secret,
+	})
+}
+
+func (h *Handler) handleDeleteSubscription(w http.ResponseWriter, r *http.Request) error {
+	deleted, err := h.dispatcher.store.DeleteSubscription(r.Context(), chi.URLParam(r, "id"))
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !deleted {
+		return u.ERROR(w, ge.NotFound)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Webhook subscription successfully removed",
+	})
+}
+
+// handleGetDeliveries lists deliveries, newest first.
+//
+//	GET /admin/webhooks/deliveries?status=dead&subscription=<id>&limit=50
+func (h *Handler) handleGetDeliveries(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultDeliveryLimit
+	}
+	if limit > maxDeliveryLimit {
+		limit = maxDeliveryLimit
+	}
+
+	deliveries, err := h.dispatcher.store.GetDeliveries(r.Context(), DeliveryFilter{
+		Status:         query.Get("status"),
+		SubscriptionID: query.Get("subscription"),
+		Limit:          limit,
+	})
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"results": deliveries,
+	})
+}
+
+func (h *Handler) handleGetDelivery(w http.ResponseWriter, r *http.Request) error {
+	delivery, err := h.dispatcher.store.GetDelivery(r.Context(), chi.URLParam(r, "id"))
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if delivery == nil {
+		return u.ERROR(w, ge.NotFound)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"results": []*Delivery{delivery},
+	})
+}
+
+// handleReplayDelivery queues a delivery again with a fresh set of attempts,
+// typically a dead one once its receiver is fixed.
+func (h *Handler) handleReplayDelivery(w http.ResponseWriter, r *http.Request) error {
+	replayed, err := h.dispatcher.store.ReplayDelivery(r.Context(), chi.URLParam(r, "id"), time.Now().UTC())
+
+	if err != nil {
+		return u.ERROR(w, ge.Internal)
+	}
+
+	if !replayed {
+		return u.ERROR(w, ge.NotFound)
+	}
+
+	return u.JSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Webhook delivery successfully queued",
+	})
+}
+
+func validURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "https" || parsed.Scheme == "http") && parsed.Host != ""
+}
+
+func validEvents(events []string) bool {
+	if len(events) == 0 {
+		return false
+	}
+
+	for _, e := range events {
+		if !(Subscription{Events: outbox.Events}).Wants(e) {
+			return false
+		}
+	}
+	return true
+}
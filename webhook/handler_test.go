@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/findsam/food-server/auth"
+	"github.com/findsam/food-server/config"
+	"github.com/go-chi/chi/v5"
+)
+
+func newTestRouter(t *testing.T) (http.Handler, *MemoryStore) {
+	key := config.Envs.AdminAPIKey
+	t.Cleanup(func() { config.Envs.AdminAPIKey = key })
+	config.Envs.AdminAPIKey = "admin"
+
+	store := NewMemoryStore()
+	r := chi.NewRouter()
+	NewHandler(NewDispatcher(store, http.DefaultClient, RetryPolicy{MaxAttempts: 1})).RegisterRoutes(r)
+	return r, store
+}
+
+func adminRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(auth.AdminKeyHeader, "admin")
+	return req
+}
+
+func TestHandler_RequiresAdminKey(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/webhooks/deliveries", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d without the admin key", rec.Code)
+	}
+}
+
+func TestHandler_CreateSubscription(t *testing.T) {
+	router, store := newTestRouter(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, adminRequest(http.MethodPost, "/admin/webhooks", `{"url":"https://example.com/hook","events":["user.created"]}`))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+
+	res := struct {
+		Secret string `json:"secret"`
+	}{}
+	json.NewDecoder(rec.Body).Decode(&res)
+
+	subs, _ := store.GetSubscriptions(context.Background())
+	if len(subs) != 1 || subs[0].Secret != res.Secret || res.Secret == "" {
+		t.Errorf("subscription not stored with the returned secret: %+v", subs)
+	}
+
+	for _, body := range []string{
+		`{"url":"ftp://example.com","events":["user.created"]}`,
+		`{"url":"https://example.com","events":["user.deleted"]}`,
+		`{"url":"https://example.com","events":[]}`,
+	} {
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, adminRequest(http.MethodPost, "/admin/webhooks", body))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", body, rec.Code)
+		}
+	}
+}
+
+func TestHandler_ListAndReplayDeliveries(t *testing.T) {
+	router, store := newTestRouter(t)
+
+	store.CreateDeliveries(context.Background(), []Delivery{
+		{ID: "a", Status: StatusDead, Attempts: 8, CreatedAt: time.Now()},
+		{ID: "b", Status: StatusDelivered, Attempts: 1, CreatedAt: time.Now()},
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, adminRequest(http.MethodGet, "/admin/webhooks/deliveries?status=dead", ""))
+
+	res := struct {
+		Results []Delivery `json:"results"`
+	}{}
+	json.NewDecoder(rec.Body).Decode(&res)
+
+	if len(res.Results) != 1 || res.Results[0].ID != "a" {
+		t.Fatalf("status filter not applied: %+v", res.Results)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, adminRequest(http.MethodPost, "/admin/webhooks/deliveries/a/replay", ""))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+
+	replayed, _ := store.GetDelivery(context.Background(), "a")
+	if replayed.Status != StatusPending || replayed.Attempts != 0 {
+		t.Errorf("delivery not queued again: %+v", replayed)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, adminRequest(http.MethodPost, "/admin/webhooks/deliveries/missing/replay", ""))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("replaying an unknown delivery: got status %d", rec.Code)
+	}
+}
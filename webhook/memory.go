@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store for a single process and for tests. Its queue does
+// not survive a restart.
+type MemoryStore struct {
+	mu            sync.Mutex
+	subscriptions map[string]Subscription
+	deliveries    map[string]Delivery
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		subscriptions: map[string]Subscription{},
+		deliveries:    map[string]Delivery{},
+	}
+}
+
+func (s *MemoryStore) CreateSubscription(ctx context.Context, sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscriptions[sub.ID] = sub
+	return nil
+}
+
+func (s *MemoryStore) GetSubscription(ctx context.Context, id string) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &sub, nil
+}
+
+func (s *MemoryStore) GetSubscriptions(ctx context.Context) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs, nil
+}
+
+func (s *MemoryStore) DeleteSubscription(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.subscriptions[id]
+	delete(s.subscriptions, id)
+	return ok, nil
+}
+
+func (s *MemoryStore) CreateDeliveries(ctx context.Context, deliveries []Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range deliveries {
+		if _, ok := s.deliveries[d.ID]; !ok {
+			s.deliveries[d.ID] = d
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetDelivery(ctx context.Context, id string) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.deliveries[id]
+	if !ok {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+func (s *MemoryStore) GetDeliveries(ctx context.Context, filter DeliveryFilter) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := []Delivery{}
+	for _, d := range s.deliveries {
+		if filter.Status != "" && d.Status != filter.Status {
+			continue
+		}
+		if filter.SubscriptionID != "" && d.SubscriptionID != filter.SubscriptionID {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	if filter.Limit > 0 && len(deliveries) > filter.Limit {
+		deliveries = deliveries[:filter.Limit]
+	}
+	return deliveries, nil
+}
+
+func (s *MemoryStore) ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due *Delivery
+	for _, d := range s.deliveries {
+		if d.Status != StatusPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		if due == nil || d.NextAttemptAt.Before(due.NextAttemptAt) {
+			d := d
+			due = &d
+		}
+	}
+
+	if due == nil {
+		return nil, nil
+	}
+
+	claimed := *due
+	due.NextAttemptAt = now.Add(lease)
+	s.deliveries[due.ID] = *due
+	return &claimed, nil
+}
+
+func (s *MemoryStore) SaveAttempt(ctx context.Context, d Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deliveries[d.ID] = d
+	return nil
+}
+
+func (s *MemoryStore) ReplayDelivery(ctx context.Context, id string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.deliveries[id]
+	if !ok {
+		return false, nil
+	}
+
+	d.Status = StatusPending
+	d.Attempts = 0
+	d.NextAttemptAt = now
+	s.deliveries[id] = d
+	return true, nil
+}
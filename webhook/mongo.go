@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/findsam/food-server/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	SubscriptionsCollName = "webhookSubscriptions"
+	DeliveriesCollName    = "webhookDeliveries"
+)
+
+// MongoStore keeps the delivery queue in Mongo, shared by every replica.
+// Replicas claim deliveries atomically, so each attempt is made once.
+type MongoStore struct {
+	db *mongo.Client
+}
+
+func NewMongoStore(db *mongo.Client) *MongoStore {
+	return &MongoStore{db: db}
+}
+
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	col := s.db.Database(db.DbName).Collection(DeliveriesCollName)
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+		{Keys: bson.D{{Key: "subscriptionId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+	})
+	return err
+}
+
+func (s *MongoStore) CreateSubscription(ctx context.Context, sub Subscription) error {
+	col := s.db.Database(db.DbName).Collection(SubscriptionsCollName)
+	_, err := col.InsertOne(ctx, sub)
+	return err
+}
+
+func (s *MongoStore) GetSubscription(ctx context.Context, id string) (*Subscription, error) {
+	col := s.db.Database(db.DbName).Collection(SubscriptionsCollName)
+
+	sub := new(Subscription)
+	err := col.FindOne(ctx, bson.M{"_id": id}).Decode(sub)
+
+	if sub.ID == "" {
+		return nil, nil
+	}
+
+	return sub, err
+}
+
+func (s *MongoStore) GetSubscriptions(ctx context.Context) ([]Subscription, error) {
+	col := s.db.Database(db.DbName).Collection(SubscriptionsCollName)
+
+	cursor, err := col.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	subs := []Subscription{}
+	err = cursor.All(ctx, &subs)
+	return subs, err
+}
+
+func (s *MongoStore) DeleteSubscription(ctx context.Context, id string) (bool, error) {
+	col := s.db.Database(db.DbName).Collection(SubscriptionsCollName)
+
+	res, err := col.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+
+	return res.DeletedCount == 1, nil
+}
+
+func (s *MongoStore) CreateDeliveries(ctx context.Context, deliveries []Delivery) error {
+	col := s.db.Database(db.DbName).Collection(DeliveriesCollName)
+
+	docs := make([]interface{}, len(deliveries))
+	for i, d := range deliveries {
+		docs[i] = d
+	}
+
+	// unordered, so one already queued delivery does not stop the others.
+	_, err := col.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if onlyDuplicates(err) {
+		return nil
+	}
+	return err
+}
+
+// onlyDuplicates reports whether every write in err failed on an existing id.
+func onlyDuplicates(err error) bool {
+	var bulk mongo.BulkWriteException
+	if !errors.As(err, &bulk) || bulk.WriteConcernError != nil {
+		return false
+	}
+
+	for _, e := range bulk.WriteErrors {
+		if e.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MongoStore) GetDelivery(ctx context.Context, id string) (*Delivery, error) {
+	col := s.db.Database(db.DbName).Collection(DeliveriesCollName)
+
+	d := new(Delivery)
+	err := col.FindOne(ctx, bson.M{"_id": id}).Decode(d)
+
+	if d.ID == "" {
+		return nil, nil
+	}
+
+	return d, err
+}
+
+func (s *MongoStore) GetDeliveries(ctx context.Context, filter DeliveryFilter) ([]Delivery, error) {
+	col := s.db.Database(db.DbName).Collection(DeliveriesCollName)
+
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.SubscriptionID != "" {
+		query["subscriptionId"] = filter.SubscriptionID
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+
+	cursor, err := col.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []Delivery{}
+	err = cursor.All(ctx, &deliveries)
+	return deliveries, err
+}
+
+func (s *MongoStore) ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*Delivery, error) {
+	col := s.db.Database(db.DbName).Collection(DeliveriesCollName)
+
+	d := new(Delivery)
+	err := col.FindOneAndUpdate(ctx, bson.M{
+		"status":        StatusPending,
+		"nextAttemptAt": bson.M{"$lte": now},
+	}, bson.M{
+		"$set": bson.M{"nextAttemptAt": now.Add(lease)},
+	}, options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}})).Decode(d)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (s *MongoStore) SaveAttempt(ctx context.Context, d Delivery) error {
+	col := s.db.Database(db.DbName).Collection(DeliveriesCollName)
+	_, err := col.UpdateOne(ctx, bson.M{"_id": d.ID}, bson.M{"$set": bson.M{
+		"status":         d.Status,
+		"attempts":       d.Attempts,
+		"nextAttemptAt":  d.NextAttemptAt,
+		"lastStatusCode": d.LastStatusCode,
+		"lastError":      d.LastError,
+		"deliveredAt":    d.DeliveredAt,
+	}})
+	return err
+}
+
+func (s *MongoStore) ReplayDelivery(ctx context.Context, id string, now time.Time) (bool, error) {
+	col := s.db.Database(db.DbName).Collection(DeliveriesCollName)
+
+	res, err := col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":        StatusPending,
+		"attempts":      0,
+		"nextAttemptAt": now,
+	}})
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount == 1, nil
+}
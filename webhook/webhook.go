@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delivery states. A pending delivery is retried until it is delivered or
+// runs out of attempts, at which point it is dead until replayed.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Subscription asks for the listed events, out of outbox.Events, to be posted
+// to URL, signed with Secret.
+type Subscription struct {
+	ID        string    `json:"id" bson:"_id"`
+	URL       string    `json:"url" bson:"url"`
+	Events    []string  `json:"events" bson:"events"`
+	Secret    string    `json:"-" bson:"secret"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// Wants reports whether the subscription asked for event.
+func (s Subscription) Wants(event string) bool {
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one event queued for one subscription. Payload is the exact
+// body that is signed and posted on every attempt.
+type Delivery struct {
+	ID             string          `json:"id" bson:"_id"`
+	SubscriptionID string          `json:"subscriptionId" bson:"subscriptionId"`
+	EventID        string          `json:"eventId" bson:"eventId"`
+	Event          string          `json:"event" bson:"event"`
+	Payload        json.RawMessage `json:"payload" bson:"payload"`
+	Status         string          `json:"status" bson:"status"`
+	Attempts       int             `json:"attempts" bson:"attempts"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LastStatusCode int             `json:"lastStatusCode,omitempty" bson:"lastStatusCode,omitempty"`
+	LastError      string          `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt" bson:"createdAt"`
+	DeliveredAt    time.Time       `json:"deliveredAt,omitempty" bson:"deliveredAt,omitempty"`
+}
+
+// DeliveryFilter narrows a listing of deliveries. Empty fields match all.
+type DeliveryFilter struct {
+	Status         string
+	SubscriptionID string
+	Limit          int
+}
+
+// Store keeps subscriptions and the delivery queue. It must survive restarts
+// for deliveries to be durable.
+type Store interface {
+	CreateSubscription(ctx context.Context, sub Subscription) error
+	GetSubscription(ctx context.Context, id string) (*Subscription, error)
+	GetSubscriptions(ctx context.Context) ([]Subscription, error)
+	DeleteSubscription(ctx context.Context, id string) (bool, error)
+
+	// CreateDeliveries skips deliveries whose id is already queued.
+	CreateDeliveries(ctx context.Context, deliveries []Delivery) error
+	GetDelivery(ctx context.Context, id string) (*Delivery, error)
+	GetDeliveries(ctx context.Context, filter DeliveryFilter) ([]Delivery, error)
+	// ClaimDelivery takes the pending delivery due longest ago and pushes its
+	// next attempt back by lease, so no other worker picks it up meanwhile.
+	ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*Delivery, error)
+	// SaveAttempt stores the outcome of an attempt on a claimed delivery.
+	SaveAttempt(ctx context.Context, d Delivery) error
+	// ReplayDelivery queues a delivery again from its first attempt. It
+	// reports false when no delivery has that id.
+	ReplayDelivery(ctx context.Context, id string, now time.Time) (bool, error)
+}
+
+// Signatures are sent in the Webhook-Signature header as
+//
+//	t=<unix timestamp>,v1=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// Receivers should recompute the HMAC with their secret and reject old
+// timestamps to stop replays.
+const (
+	SignatureHeader = "Webhook-Signature"
+	IDHeader        = "Webhook-Id"
+	EventHeader     = "Webhook-Event"
+)
+
+var (
+	ErrSignature      = errors.New("webhook signature does not match")
+	ErrSignatureStale = errors.New("webhook signature is too old")
+)
+
+// Sign returns the Webhook-Signature header value for body sent at ts.
+func Sign(secret string, ts time.Time, body []byte) string {
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + stamp + ",v1=" + hex.EncodeToString(mac(secret, stamp, body))
+}
+
+// Verify checks a Webhook-Signature header against body, refusing signatures
+// made more than tolerance before now.
+func Verify(secret string, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var stamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			stamp = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	ts, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return ErrSignature
+	}
+
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, mac(secret, stamp, body)) {
+		return ErrSignature
+	}
+
+	if now.Sub(time.Unix(ts, 0)) > tolerance {
+		return ErrSignatureStale
+	}
+	return nil
+}
+
+func mac(secret string, stamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(stamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// NewSecret returns a random signing secret for a new subscription.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"type":"user.created"}`)
+	now := time.Now()
+	header := Sign("secret", now, body)
+
+	if err := Verify("secret", header, body, now, time.Minute); err != nil {
+		t.Fatalf("valid signature refused: %v", err)
+	}
+
+	if err := Verify("other", header, body, now, time.Minute); err != ErrSignature {
+		t.Errorf("wrong secret: got %v", err)
+	}
+
+	if err := Verify("secret", header, []byte(`{}`), now, time.Minute); err != ErrSignature {
+		t.Errorf("tampered body: got %v", err)
+	}
+
+	if err := Verify("secret", header, body, now.Add(time.Hour), time.Minute); err != ErrSignatureStale {
+		t.Errorf("old signature: got %v", err)
+	}
+
+	if err := Verify("secret", "garbage", body, now, time.Minute); err != ErrSignature {
+		t.Errorf("malformed header: got %v", err)
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, errA := NewSecret()
+	b, errB := NewSecret()
+
+	if errA != nil || errB != nil {
+		t.Fatal("error generating secrets")
+	}
+
+	if a == b {
+		t.Error("secrets should be random")
+	}
+}